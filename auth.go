@@ -0,0 +1,408 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ---- Auth (shared token) ----
+
+type authConfig struct {
+	// tokens are the accepted shared tokens. Each works independently, so
+	// one can be revoked without logging everyone else out.
+	tokens []string
+	// exempt paths are served without a token (e.g. /healthz); one ending
+	// in "/" covers everything under it.
+	exempt []string
+	// basicUser, if set, is the username Basic Auth must present.
+	basicUser string
+	// shareKey signs share links; a valid ?exp=&sig= on /photos/<name>
+	// stands in for the token on that one photo.
+	shareKey []byte
+	// trusted networks (TRUSTED_CIDRS) skip the token entirely.
+	trusted []*net.IPNet
+	// cookieName and cookieMaxAge (seconds) describe the login cookie.
+	cookieName   string
+	cookieMaxAge int
+	// cookieDomain ("" = this host only) and cookieSameSite
+	// (COOKIE_DOMAIN, COOKIE_SAMESITE) let the cookie span subdomains or
+	// work inside cross-site frames.
+	cookieDomain   string
+	cookieSameSite http.SameSite
+}
+
+// maxAuthFormBytes bounds the POST /auth form; it only carries a token.
+const maxAuthFormBytes = 4 << 10
+
+// authMiddleware requires one of the configured tokens on every request
+// except those for the exempt paths.
+func authMiddleware(cfg authConfig, next http.Handler) http.Handler {
+	tokens := cfg.tokens
+	limiter := newAuthLimiter()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Let exempt paths (e.g. /healthz for infra health checks) pass.
+		if isExemptPath(r.URL.Path, cfg.exempt) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Devices on a trusted network (e.g. the home LAN) need no token.
+		if inNetworks(clientIP(r), cfg.trusted) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Clients that keep presenting wrong tokens are cut off for a while,
+		// before any token is checked, so guessing can't continue.
+		client := rateLimitKey(r)
+		if retryAfter, blocked := limiter.blocked(client); blocked {
+			tooManyAttempts(w, retryAfter)
+			return
+		}
+		attempted := false
+
+		// Token typed into the form on the unauthorized page, for TV
+		// browsers that mangle query strings or can't edit URLs.
+		if r.URL.Path == "/auth" && r.Method == http.MethodPost {
+			r.Body = http.MaxBytesReader(w, r.Body, maxAuthFormBytes)
+			if provided := strings.TrimSpace(r.PostFormValue("token")); provided != "" {
+				if token, ok := matchToken(tokens, provided); ok {
+					setAuthCookie(w, r, cfg, token)
+					http.Redirect(w, r, "/", http.StatusSeeOther)
+					return
+				}
+				limiter.fail(client)
+			}
+			unauthorized(w, r, cfg.basicUser != "")
+			return
+		}
+
+		// Signed share link for a single photo
+		if name, ok := strings.CutPrefix(r.URL.Path, "/photos/"); ok && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+			if sig := r.URL.Query().Get("sig"); sig != "" {
+				attempted = true
+				if validShareSig(cfg.shareKey, name, r.URL.Query().Get("exp"), sig, time.Now()) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+		}
+
+		// If user provides token via query string once, set cookie then redirect.
+		// Accept token=... or t=...
+		q := r.URL.Query()
+		if provided := firstNonEmpty(q.Get("token"), q.Get("t")); provided != "" {
+			attempted = true
+			if token, ok := matchToken(tokens, provided); ok {
+				// The cookie remembers whichever token was used.
+				setAuthCookie(w, r, cfg, token)
+
+				// Redirect to same URL with token removed (so you can bookmark clean URLs later).
+				cleanURL := *r.URL
+				cq := cleanURL.Query()
+				cq.Del("token")
+				cq.Del("t")
+				cleanURL.RawQuery = cq.Encode()
+
+				http.Redirect(w, r, cleanURL.String(), http.StatusFound)
+				return
+			}
+			// If they tried a token and it's wrong, fall through to unauthorized response.
+		}
+
+		// Cookie auth
+		if c, err := r.Cookie(cfg.cookieName); err == nil && c != nil {
+			attempted = true
+			if _, ok := matchToken(tokens, c.Value); ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		// Bearer token auth
+		if bearer := parseBearer(r.Header.Get("Authorization")); bearer != "" {
+			attempted = true
+			if _, ok := matchToken(tokens, bearer); ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		// X-Auth-Token header, for setups where a proxy rewrites Authorization
+		if header := strings.TrimSpace(r.Header.Get("X-Auth-Token")); header != "" {
+			attempted = true
+			if _, ok := matchToken(tokens, header); ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		// Basic auth (older devices and scripts)
+		if user, pass, ok := r.BasicAuth(); ok {
+			attempted = true
+			if basicAuthOK(cfg, user, pass) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		// Only wrong credentials count; a device that simply hasn't been set
+		// up yet just sees the instructions.
+		if attempted {
+			limiter.fail(client)
+		}
+		unauthorized(w, r, cfg.basicUser != "")
+	})
+}
+
+func setAuthCookie(w http.ResponseWriter, r *http.Request, cfg authConfig, token string) {
+	// Browsers drop SameSite=None cookies that aren't Secure.
+	secure := isProbablyHTTPS(r) || cfg.cookieSameSite == http.SameSiteNoneMode
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     cfg.cookieName,
+		Value:    token,
+		Path:     "/",
+		Domain:   cfg.cookieDomain,
+		MaxAge:   cfg.cookieMaxAge,
+		HttpOnly: true,
+		SameSite: cfg.cookieSameSite,
+		Secure:   secure,
+	})
+}
+
+// parseSameSite reads COOKIE_SAMESITE: lax (the default), strict or none.
+func parseSameSite(s string) (http.SameSite, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	}
+	return 0, fmt.Errorf("%q (want lax, strict or none)", s)
+}
+
+// validCookieDomain reports whether d is a host name a cookie can be
+// scoped to, e.g. "example.com" or ".example.com" (the dot is optional).
+func validCookieDomain(d string) bool {
+	d = strings.TrimPrefix(d, ".")
+	if d == "" || len(d) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(d, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// validCookieName reports whether name is safe to use as a cookie name.
+// (RFC 6265 allows more, but these are the characters every client handles.)
+func validCookieName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// unauthorized tells the client which schemes work (WWW-Authenticate) and
+// answers with a small JSON error for API clients that prefer it, or a
+// setup page for everyone else. The Basic challenge is only sent with
+// BASIC_AUTH_USER set, since it makes browsers pop up a login dialog.
+func unauthorized(w http.ResponseWriter, r *http.Request, basicChallenge bool) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Add("WWW-Authenticate", `Bearer realm="frameserve"`)
+	if basicChallenge {
+		w.Header().Add("WWW-Authenticate", `Basic realm="frameserve", charset="UTF-8"`)
+	}
+
+	if prefersJSON(r.Header.Get("Accept")) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = io.WriteString(w, `{"error":"unauthorized"}`+"\n")
+		return
+	}
+
+	// Minimal, human-friendly response that works on TVs/kiosks.
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusUnauthorized)
+
+	_, _ = io.WriteString(w, `<!doctype html>
+<html lang="en">
+<head>
+  <meta charset="utf-8"/>
+  <meta name="viewport" content="width=device-width,initial-scale=1"/>
+  <title>Frameserve · Unauthorized</title>
+  <link rel="icon" type="image/svg+xml" href="/static/camera.svg" />
+  <link rel="apple-touch-icon" href="/static/camera.svg" />
+  <meta name="theme-color" content="#000000" />
+  <link rel="stylesheet" href="/static/info.css" />
+</head>
+<body>
+  <div class="wrap">
+    <div class="card">
+      <h1>Unauthorized</h1>
+      <p>This Frameserve instance requires a shared access token.</p>
+      <p><strong>One-time setup on this device:</strong></p>
+      <p>Open this URL once (replace <code>YOURTOKEN</code>):</p>
+      <p><code>`+htmlEscape(r.URL.Path)+`?token=YOURTOKEN</code></p>
+      <form class="token-form" method="post" action="/auth">
+        <p><label for="token">Or type it in here:</label></p>
+        <input id="token" name="token" type="password" autocomplete="current-password" required />
+        <button class="btn" type="submit">Log in</button>
+      </form>
+      <p>After that, the device will stay logged in via a long-lived cookie.</p>
+      <p class="muted">If you cleared cookies or switched browsers, repeat the one-time setup.</p>
+      <div class="actions">
+        <a class="btn" href="/info">How it works</a>
+      </div>
+    </div>
+  </div>
+</body>
+</html>`)
+}
+
+// basicAuthOK checks Basic Auth credentials: the token goes in the password.
+// Without a configured username any username is accepted. The token is
+// never taken from the username, which ends up in logs and user@host URLs.
+func basicAuthOK(cfg authConfig, user, pass string) bool {
+	_, passOK := matchToken(cfg.tokens, pass)
+	if cfg.basicUser != "" {
+		userOK := constantTimeEqual([]byte(cfg.basicUser), []byte(user))
+		return userOK && passOK
+	}
+	return passOK
+}
+
+// matchToken returns the configured token equal to provided. Every token is
+// compared (in constant time) so timing doesn't reveal which one matched.
+func matchToken(tokens []string, provided string) (string, bool) {
+	var match string
+	found := false
+	for _, t := range tokens {
+		if constantTimeEqual([]byte(t), []byte(provided)) && !found {
+			match = t
+			found = true
+		}
+	}
+	return match, found
+}
+
+// parseTokenList splits a comma-separated token list, dropping blanks.
+func parseTokenList(s string) []string {
+	var tokens []string
+	for _, t := range strings.Split(s, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens
+}
+
+// parseExemptPaths parses AUTH_EXEMPT_PATHS: comma-separated paths, each
+// matched exactly, or as a prefix when it ends in "/".
+func parseExemptPaths(s string) ([]string, error) {
+	var paths []string
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if !strings.HasPrefix(p, "/") {
+			return nil, fmt.Errorf("%q must start with /", p)
+		}
+		paths = append(paths, p)
+	}
+	return paths, nil
+}
+
+func isExemptPath(urlPath string, exempt []string) bool {
+	for _, p := range exempt {
+		if urlPath == p || (strings.HasSuffix(p, "/") && strings.HasPrefix(urlPath, p)) {
+			return true
+		}
+	}
+	return false
+}
+
+// exposesContent reports whether exempting p would let photos or the
+// listing through without a token.
+func exposesContent(p string) bool {
+	for _, content := range []string{"/photos/", "/thumb/", "/api/"} {
+		if isExemptPath(content+"x", []string{p}) {
+			return true
+		}
+	}
+	return false
+}
+
+func constantTimeEqual(a, b []byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	return subtle.ConstantTimeCompare(a, b) == 1
+}
+
+func parseBearer(authz string) string {
+	authz = strings.TrimSpace(authz)
+	if authz == "" {
+		return ""
+	}
+	parts := strings.SplitN(authz, " ", 2)
+	if len(parts) != 2 {
+		return ""
+	}
+	if strings.ToLower(strings.TrimSpace(parts[0])) != "bearer" {
+		return ""
+	}
+	return strings.TrimSpace(parts[1])
+}
+
+func firstNonEmpty(a, b string) string {
+	if strings.TrimSpace(a) != "" {
+		return a
+	}
+	return b
+}
+
+func isProbablyHTTPS(r *http.Request) bool {
+	// Direct TLS
+	if r.TLS != nil {
+		return true
+	}
+	// Common reverse-proxy headers
+	if strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") {
+		return true
+	}
+	return false
+}
+
+func htmlEscape(s string) string {
+	repl := strings.NewReplacer(
+		"&", "&amp;",
+		"<", "&lt;",
+		">", "&gt;",
+		`"`, "&quot;",
+		"'", "&#39;",
+	)
+	return repl.Replace(s)
+}
@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestBasicAuthOK(t *testing.T) {
+	tests := []struct {
+		name      string
+		basicUser string
+		user      string
+		pass      string
+		want      bool
+	}{
+		{name: "token as password", user: "anyone", pass: "s3cret", want: true},
+		{name: "token as password, no user", pass: "s3cret", want: true},
+		{name: "second token", user: "anyone", pass: "other", want: true},
+		{name: "wrong password", user: "anyone", pass: "nope", want: false},
+		{name: "token as username", user: "s3cret", want: false},
+		{name: "token as username with password", user: "s3cret", pass: "nope", want: false},
+		{name: "pinned user", basicUser: "frame", user: "frame", pass: "s3cret", want: true},
+		{name: "pinned user, wrong user", basicUser: "frame", user: "other", pass: "s3cret", want: false},
+		{name: "pinned user, token as username", basicUser: "frame", user: "s3cret", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := authConfig{tokens: []string{"s3cret", "other"}, basicUser: tt.basicUser}
+			if got := basicAuthOK(cfg, tt.user, tt.pass); got != tt.want {
+				t.Errorf("basicAuthOK(%q, %q) = %v, want %v", tt.user, tt.pass, got, tt.want)
+			}
+		})
+	}
+}
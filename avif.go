@@ -0,0 +1,13 @@
+//go:build avif
+
+package main
+
+import "github.com/gen2brain/avif"
+
+// AVIF decoding (pure Go, via WebAssembly) noticeably grows the binary, so
+// it's opt-in:
+//
+//	go build -tags avif .
+func init() {
+	transcodeDecoders[".avif"] = avif.Decode
+}
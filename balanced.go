@@ -0,0 +1,52 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// ---- Album-balanced order (order=album_balanced) ----
+
+// topAlbum is the top-level folder a photo lives in, or "" for photos at
+// the root of PHOTOS_DIR, which form a group of their own.
+func topAlbum(name string) string {
+	album, _, ok := strings.Cut(name, "/")
+	if !ok {
+		return ""
+	}
+	return album
+}
+
+// albumBalanced deals photos round-robin from their top-level albums (in
+// name order, root first), each album newest first. Smaller albums start
+// over once they run out, until the largest has come up in full, so a
+// 50-photo album gets as much screen time as a 1000-photo one; like
+// order=weighted, the result is longer than the input.
+func albumBalanced(photos []Photo) []Photo {
+	sortByKey(photos, func(p Photo) int64 { return p.Mtime }, true)
+	groups := make(map[string][]Photo)
+	for _, p := range photos {
+		album := topAlbum(p.Name)
+		groups[album] = append(groups[album], p)
+	}
+	if len(groups) < 2 {
+		return photos
+	}
+
+	albums := make([]string, 0, len(groups))
+	longest := 0
+	for album, g := range groups {
+		albums = append(albums, album)
+		longest = max(longest, len(g))
+	}
+	sort.Strings(albums)
+
+	playlist := make([]Photo, 0, longest*len(albums))
+	for i := 0; i < longest; i++ {
+		for _, album := range albums {
+			g := groups[album]
+			playlist = append(playlist, g[i%len(g)])
+		}
+	}
+	return playlist
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"image"
+	"os"
+	"sync"
+
+	"github.com/buckket/go-blurhash"
+	"golang.org/x/image/draw"
+)
+
+// ---- BlurHash placeholders (BLURHASH=true) ----
+
+const (
+	// blurhashSize is the width images are shrunk to before encoding; the
+	// hash only keeps a handful of components, so more pixels buy nothing.
+	blurhashSize = 32
+	// blurhashX and blurhashY are the component counts (4x3 suits photos).
+	blurhashX, blurhashY = 4, 3
+)
+
+// blurhashes is nil unless BLURHASH=true.
+var blurhashes *blurhashCache
+
+// blurhashCache computes BlurHash strings in the background, one image at a
+// time, and remembers them per file path while the mtime is unchanged. A
+// listing never waits for them: photos get their hash on a later scan, which
+// onReady triggers once the queue runs dry.
+type blurhashCache struct {
+	onReady func()
+	jobs    chan blurhashJob
+
+	mu      sync.Mutex
+	entries map[string]blurhashEntry
+	pending map[string]bool
+}
+
+type blurhashJob struct {
+	fullPath    string
+	mtime       int64
+	orientation int
+}
+
+type blurhashEntry struct {
+	mtime int64
+	hash  string // "" if the image couldn't be decoded
+}
+
+func newBlurhashCache(onReady func()) *blurhashCache {
+	c := &blurhashCache{
+		onReady: onReady,
+		jobs:    make(chan blurhashJob, 1024),
+		entries: make(map[string]blurhashEntry),
+		pending: make(map[string]bool),
+	}
+	go c.work()
+	return c
+}
+
+// get returns the cached hash for fullPath, or "" after queueing it.
+func (c *blurhashCache) get(fullPath string, mtime int64, orientation int) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[fullPath]; ok && e.mtime == mtime {
+		return e.hash
+	}
+	if c.pending[fullPath] {
+		return ""
+	}
+	select {
+	case c.jobs <- blurhashJob{fullPath, mtime, orientation}:
+		c.pending[fullPath] = true
+	default:
+		// Queue full; the next scan asks again.
+	}
+	return ""
+}
+
+func (c *blurhashCache) work() {
+	for job := range c.jobs {
+		hash := computeBlurhash(job.fullPath, job.orientation)
+
+		c.mu.Lock()
+		c.entries[job.fullPath] = blurhashEntry{mtime: job.mtime, hash: hash}
+		delete(c.pending, job.fullPath)
+		idle := len(c.pending) == 0
+		c.mu.Unlock()
+
+		if idle && c.onReady != nil {
+			c.onReady()
+		}
+	}
+}
+
+// computeBlurhash decodes the image, turns it upright and encodes a small
+// copy. Failures (formats this build can't decode, corrupt files) give "".
+func computeBlurhash(fullPath string, orientation int) string {
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return ""
+	}
+	src, _, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		return ""
+	}
+	src = applyOrientation(src, orientation)
+
+	b := src.Bounds()
+	if b.Dx() < 1 || b.Dy() < 1 {
+		return ""
+	}
+	width := min(b.Dx(), blurhashSize)
+	height := max(b.Dy()*width/b.Dx(), 1)
+	small := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.BiLinear.Scale(small, small.Bounds(), src, b, draw.Src, nil)
+
+	hash, err := blurhash.Encode(blurhashX, blurhashY, small)
+	if err != nil {
+		return ""
+	}
+	return hash
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ---- Captions from sidecar files ----
+
+// maxCaptionBytes bounds how much of a sidecar is read.
+const maxCaptionBytes = 64 << 10
+
+// captionSidecars are the files a photo's caption may come from, first
+// found wins: for beach.jpg, beach.jpg.txt, beach.jpg.json, beach.txt and
+// beach.json. Sidecars never show up as photos themselves, since .txt and
+// .json aren't image extensions.
+func captionSidecars(fullPath string) []string {
+	stem := strings.TrimSuffix(fullPath, filepath.Ext(fullPath))
+	return []string{fullPath + ".txt", fullPath + ".json", stem + ".txt", stem + ".json"}
+}
+
+// captionCache remembers parsed sidecars per path while their mtime
+// matches, so rescans only stat them.
+type captionCache struct {
+	mu      sync.Mutex
+	entries map[string]captionEntry
+}
+
+type captionEntry struct {
+	mtime   int64 // unix nanoseconds; captions get edited quickly
+	caption string
+}
+
+var photoCaptions = &captionCache{entries: make(map[string]captionEntry)}
+
+// get returns the caption for the photo at fullPath, or "" if it has none.
+func (c *captionCache) get(fullPath string) string {
+	for _, sidecar := range captionSidecars(fullPath) {
+		fi, err := os.Stat(sidecar)
+		if err != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+		mtime := fi.ModTime().UnixNano()
+
+		c.mu.Lock()
+		e, ok := c.entries[sidecar]
+		c.mu.Unlock()
+		if ok && e.mtime == mtime {
+			return e.caption
+		}
+
+		caption := readCaption(sidecar)
+		c.mu.Lock()
+		c.entries[sidecar] = captionEntry{mtime: mtime, caption: caption}
+		c.mu.Unlock()
+		return caption
+	}
+	return ""
+}
+
+// readCaption parses a sidecar: the first line of a .txt, or the "caption"
+// string of a .json. Unreadable or malformed files give "".
+func readCaption(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	r := io.LimitReader(f, maxCaptionBytes)
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		var v struct {
+			Caption string `json:"caption"`
+		}
+		if json.NewDecoder(r).Decode(&v) != nil {
+			return ""
+		}
+		return strings.TrimSpace(v.Caption)
+	}
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 4096), maxCaptionBytes)
+	if !sc.Scan() {
+		return ""
+	}
+	// Editors on Windows like to start files with a BOM.
+	return strings.TrimSpace(strings.TrimPrefix(sc.Text(), "\ufeff"))
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ---- Case-insensitive photo names (CASE_INSENSITIVE) ----
+
+// caseInsensitive (CASE_INSENSITIVE) lets /photos/IMG_1.JPG find
+// img_1.jpg, for photos copied between devices that disagree on case.
+var caseInsensitive = false
+
+// foldPhotoName finds the photo whose name matches name except for case,
+// one path element at a time so folder names may differ in case too. An
+// exact match wins over a folded one; among folded matches, the first in
+// name order does. The result still has to pass lookupPhoto, so the usual
+// path, extension, ignore and symlink checks apply to it.
+func foldPhotoName(baseDir string, recursive bool, name string) (string, bool) {
+	if _, valid := photoPath(baseDir, recursive, name); !valid {
+		return "", false
+	}
+
+	dir := baseDir
+	elems := strings.Split(name, "/")
+	for i, elem := range elems {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return "", false
+		}
+		match := ""
+		for _, e := range entries {
+			if e.Name() == elem {
+				match = elem
+				break
+			}
+			if match == "" && strings.EqualFold(e.Name(), elem) {
+				match = e.Name()
+			}
+		}
+		if match == "" {
+			return "", false
+		}
+		elems[i] = match
+		dir = filepath.Join(dir, match)
+	}
+	return strings.Join(elems, "/"), true
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ---- Cast webhook (CAST_WEBHOOK) ----
+
+const (
+	// castAttempts is how often a push is tried before giving up on it.
+	castAttempts = 4
+	// castBackoff is the wait before the first retry; it doubles after each.
+	castBackoff = time.Second
+)
+
+// CastEvent is the JSON body POSTed to CAST_WEBHOOK on every change.
+type CastEvent struct {
+	// URL is where the receiver can fetch the photo: absolute when
+	// CAST_BASE_URL is set, and signed (no token needed) with auth on.
+	URL   string `json:"url"`
+	Name  string `json:"name"`
+	Index int    `json:"index"`
+	Count int    `json:"count"`
+	// ChangedAt is unix milliseconds.
+	ChangedAt int64 `json:"changed_at_ms"`
+}
+
+// castWebhook pushes each photo the synced slideshow moves to, for displays
+// that are driven over HTTP rather than running the frontend.
+type castWebhook struct {
+	target   string
+	baseURL  string // prefix for photo URLs, e.g. http://frame.lan:8080
+	shareKey []byte // signs photo URLs when auth is on
+	client   *http.Client
+}
+
+func newCastWebhook(target, baseURL string, shareKey []byte) *castWebhook {
+	return &castWebhook{
+		target:   target,
+		baseURL:  strings.TrimRight(baseURL, "/"),
+		shareKey: shareKey,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// run follows show like a synced frame would, until the process exits.
+func (c *castWebhook) run(show *slideshow) {
+	ch := show.subscribe()
+	for range ch {
+		st := show.state()
+		if st.Photo == nil {
+			continue
+		}
+		c.push(c.event(st), ch)
+	}
+}
+
+func (c *castWebhook) event(st SlideshowState) CastEvent {
+	u := st.Photo.URL
+	if len(c.shareKey) > 0 {
+		u = shareURL(c.shareKey, st.Photo.Name, time.Now().Add(defaultShareTTL).Unix())
+	}
+	return CastEvent{
+		URL:       c.baseURL + u,
+		Name:      st.Photo.Name,
+		Index:     st.Index,
+		Count:     st.Count,
+		ChangedAt: st.ChangedAt,
+	}
+}
+
+// push POSTs ev, retrying with backoff. It gives up early once the
+// slideshow has moved on (a wakeup is pending on next): that photo is what
+// the receiver should get instead.
+func (c *castWebhook) push(ev CastEvent, next <-chan struct{}) {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	enc.SetEscapeHTML(false) // keep & in signed URLs readable
+	if err := enc.Encode(ev); err != nil {
+		return
+	}
+	wait := castBackoff
+	for attempt := 1; ; attempt++ {
+		err := c.post(body.Bytes())
+		if err == nil {
+			return
+		}
+		if attempt == castAttempts {
+			log.Printf("cast webhook: giving up on %s after %d attempts: %v", ev.Name, attempt, err)
+			return
+		}
+		log.Printf("cast webhook: %v; retrying in %s", err, wait)
+		time.Sleep(wait)
+		if len(next) > 0 {
+			return
+		}
+		wait *= 2
+	}
+}
+
+func (c *castWebhook) post(body []byte) error {
+	resp, err := c.client.Post(c.target, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s answered %s", c.target, resp.Status)
+	}
+	return nil
+}
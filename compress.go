@@ -0,0 +1,162 @@
+package main
+
+import (
+	"compress/gzip"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ---- Compression (gzip) ----
+
+// gzipMinSize is the smallest body worth compressing; below it the gzip
+// framing costs more than it saves.
+const gzipMinSize = 1024
+
+var gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(nil) }}
+
+// gzipMiddleware gzips text responses (HTML, CSS, JS, JSON, SVG) for
+// clients that accept it. Photos and thumbnails are skipped up front: they
+// are already compressed formats and rely on Range requests.
+func gzipMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/photos/") || strings.HasPrefix(r.URL.Path, "/thumb/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+		if r.Header.Get("Range") != "" || !acceptsMediaType(r.Header.Get("Accept-Encoding"), "gzip") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		defer gw.close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// gzipResponseWriter holds back the header and the first gzipMinSize bytes
+// so it can decide whether to compress based on the final Content-Type and
+// the body size.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	decided     bool
+	buf         []byte
+	gz          *gzip.Writer
+}
+
+func (g *gzipResponseWriter) WriteHeader(code int) {
+	if g.wroteHeader {
+		return
+	}
+	g.wroteHeader = true
+	g.status = code
+	// Bodiless and informational responses pass straight through.
+	if code < 200 || code == http.StatusNoContent || code == http.StatusNotModified {
+		g.decided = true
+		g.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (g *gzipResponseWriter) Write(p []byte) (int, error) {
+	if !g.wroteHeader {
+		g.WriteHeader(http.StatusOK)
+	}
+	if !g.decided {
+		if !compressible(g.Header()) {
+			g.decide(false)
+		} else {
+			g.buf = append(g.buf, p...)
+			if len(g.buf) < gzipMinSize {
+				return len(p), nil
+			}
+			g.decide(true)
+			return len(p), nil
+		}
+	}
+	if g.gz != nil {
+		return g.gz.Write(p)
+	}
+	return g.ResponseWriter.Write(p)
+}
+
+// decide sends the header, compressed or not, followed by anything buffered.
+func (g *gzipResponseWriter) decide(compress bool) {
+	g.decided = true
+	if compress {
+		g.Header().Set("Content-Encoding", "gzip")
+		g.Header().Del("Content-Length")
+		g.gz = gzipWriters.Get().(*gzip.Writer)
+		g.gz.Reset(g.ResponseWriter)
+	}
+	g.ResponseWriter.WriteHeader(g.status)
+	if len(g.buf) > 0 {
+		if g.gz != nil {
+			_, _ = g.gz.Write(g.buf)
+		} else {
+			_, _ = g.ResponseWriter.Write(g.buf)
+		}
+		g.buf = nil
+	}
+}
+
+// Flush lets long-polls and streams push partial output.
+func (g *gzipResponseWriter) Flush() {
+	if !g.wroteHeader {
+		g.WriteHeader(http.StatusOK)
+	}
+	if !g.decided {
+		g.decide(len(g.buf) >= gzipMinSize && compressible(g.Header()))
+	}
+	if g.gz != nil {
+		_ = g.gz.Flush()
+	}
+	if f, ok := g.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (g *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+func (g *gzipResponseWriter) close() {
+	if !g.decided {
+		if !g.wroteHeader {
+			// The handler wrote nothing at all; let net/http send its default.
+			return
+		}
+		g.decide(false)
+	}
+	if g.gz != nil {
+		_ = g.gz.Close()
+		gzipWriters.Put(g.gz)
+		g.gz = nil
+	}
+}
+
+// compressible reports whether a response with these headers is text worth
+// gzipping and isn't encoded already (promhttp, for one, gzips itself).
+func compressible(h http.Header) bool {
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "text/") && mediaType != "text/event-stream":
+		return true
+	case mediaType == "application/json", mediaType == "application/javascript",
+		mediaType == "image/svg+xml", mediaType == "application/manifest+json":
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// ---- CORS (CORS_ORIGINS) ----
+
+// corsMiddleware lets pages on the allowed origins call the /api/ routes
+// from the browser. It runs outside auth because preflight requests never
+// carry credentials. An origin of "*" allows any site, but without
+// credentials, as browsers require. Preflights are answered with the
+// methods the route allows, looked up as optionsMiddleware does.
+func corsMiddleware(origins []string, mux *http.ServeMux, methods func(pattern, path string) string, next http.Handler) http.Handler {
+	allowed := make(map[string]bool, len(origins))
+	for _, o := range origins {
+		allowed[o] = true
+	}
+	anyOrigin := allowed["*"]
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// The response depends on Origin, so caches must keep them apart.
+		w.Header().Add("Vary", "Origin")
+
+		origin := r.Header.Get("Origin")
+		switch {
+		case origin == "":
+			next.ServeHTTP(w, r)
+			return
+		case allowed[origin]:
+			// Echo the exact origin so cookies / Authorization may be sent.
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		case anyOrigin:
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		default:
+			// Not allowed: no CORS headers, so the browser blocks the read.
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Expose-Headers", "ETag, X-Photos-Hash, X-Request-ID")
+
+		// Preflight: answer it here instead of passing it to the handlers.
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			allow := ""
+			if _, pattern := mux.Handler(r); pattern != "" {
+				allow = methods(pattern, r.URL.Path)
+			}
+			if allow == "" {
+				// Not a route: let optionsMiddleware 404 it.
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Methods", allow)
+			// Content-Type for the JSON and multipart POST bodies.
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, X-Auth-Token, If-None-Match, X-Request-ID")
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// parseOriginList splits CORS_ORIGINS, dropping blanks and trailing slashes
+// (browsers send "https://example.com", never "https://example.com/").
+func parseOriginList(s string) []string {
+	var origins []string
+	for _, o := range strings.Split(s, ",") {
+		if o = strings.TrimRight(strings.TrimSpace(o), "/"); o != "" {
+			origins = append(origins, o)
+		}
+	}
+	return origins
+}
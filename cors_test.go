@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCORSPreflight(t *testing.T) {
+	mux := http.NewServeMux()
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	mux.HandleFunc("/api/photos/meta", ok)
+	mux.HandleFunc("/api/upload", ok)
+	mux.HandleFunc("/api/stats", ok)
+	routes := map[string]string{
+		"/api/photos/meta": http.MethodPost,
+		"/api/upload":      http.MethodPost,
+		"/api/stats":       http.MethodGet,
+	}
+	methods := func(pattern, path string) string { return routes[pattern] }
+	var handler http.Handler = optionsMiddleware(mux, methods, mux)
+	handler = corsMiddleware([]string{"https://dash.example.com"}, mux, methods, handler)
+
+	tests := []struct {
+		name        string
+		path        string
+		method      string
+		wantStatus  int
+		wantMethods string
+	}{
+		{"meta", "/api/photos/meta", http.MethodPost, http.StatusNoContent, "POST"},
+		{"upload", "/api/upload", http.MethodPost, http.StatusNoContent, "POST"},
+		{"stats", "/api/stats", http.MethodGet, http.StatusNoContent, "GET"},
+		{"no such route", "/api/nope", http.MethodGet, http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodOptions, tt.path, nil)
+			r.Header.Set("Origin", "https://dash.example.com")
+			r.Header.Set("Access-Control-Request-Method", tt.method)
+			r.Header.Set("Access-Control-Request-Headers", "content-type")
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Methods"); got != tt.wantMethods {
+				t.Errorf("Access-Control-Allow-Methods = %q, want %q", got, tt.wantMethods)
+			}
+			if tt.wantStatus != http.StatusNoContent {
+				return
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://dash.example.com" {
+				t.Errorf("Access-Control-Allow-Origin = %q", got)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Headers"); !strings.Contains(got, "Content-Type") {
+				t.Errorf("Access-Control-Allow-Headers = %q, want Content-Type", got)
+			}
+		})
+	}
+}
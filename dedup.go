@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"os"
+	"sync"
+)
+
+// ---- Duplicate detection (?dedup=true) ----
+
+type Duplicate struct {
+	Name string `json:"name"`
+	// DuplicateOf is the photo that was kept instead.
+	DuplicateOf string `json:"duplicate_of"`
+}
+
+// hashCache remembers content hashes per file path, reused while the size
+// and mtime are unchanged.
+type hashCache struct {
+	mu      sync.Mutex
+	entries map[string]hashEntry
+}
+
+type hashEntry struct {
+	size, mtime int64
+	sum         string
+}
+
+var contentHashes = &hashCache{entries: make(map[string]hashEntry)}
+
+// get returns the hex SHA-256 of the file, or "" if it can't be read.
+func (c *hashCache) get(fullPath string, size, mtime int64) string {
+	c.mu.Lock()
+	e, ok := c.entries[fullPath]
+	c.mu.Unlock()
+	if ok && e.size == size && e.mtime == mtime {
+		return e.sum
+	}
+
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		log.Printf("hash error: %s: %v", fullPath, err)
+		return ""
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+
+	c.mu.Lock()
+	c.entries[fullPath] = hashEntry{size: size, mtime: mtime, sum: sum}
+	c.mu.Unlock()
+	return sum
+}
+
+// dedupPhotos collapses byte-identical photos, keeping the earliest by
+// mtime (then name) of each set. Only files that share their size with
+// another file are hashed, so a library without duplicates costs next to
+// nothing. photos is reordered in place.
+func dedupPhotos(baseDir string, photos []Photo) ([]Photo, []Duplicate) {
+	bySize := make(map[int64]int, len(photos))
+	for _, p := range photos {
+		bySize[p.Size]++
+	}
+
+	sortByKey(photos, func(p Photo) int64 { return p.Mtime }, false)
+
+	var dupes []Duplicate
+	firstBySum := make(map[string]string)
+	kept := photos[:0]
+	for _, p := range photos {
+		if bySize[p.Size] > 1 {
+			if fullPath, err := safeJoin(baseDir, p.Name); err == nil {
+				if sum := contentHashes.get(fullPath, p.Size, p.Mtime); sum != "" {
+					if first, ok := firstBySum[sum]; ok {
+						dupes = append(dupes, Duplicate{Name: p.Name, DuplicateOf: first})
+						continue
+					}
+					firstBySum[sum] = p.Name
+				}
+			}
+		}
+		kept = append(kept, p)
+	}
+	return kept, dupes
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ---- Downloads (/photos/<name>?download=1) ----
+
+// wantsDownload reports whether the request asks for the photo as a file to
+// save rather than to display.
+func wantsDownload(r *http.Request) bool {
+	v, _ := strconv.ParseBool(r.URL.Query().Get("download"))
+	return v
+}
+
+// attachmentDisposition is a Content-Disposition that saves the file under
+// name. Per RFC 6266, filename= carries an ASCII stand-in for old clients
+// and filename*= (RFC 5987) the exact UTF-8 name for everyone else.
+func attachmentDisposition(name string) string {
+	fallback := strings.Map(func(r rune) rune {
+		if r > unicode.MaxASCII || r < ' ' || r == '"' || r == '\\' || r == 0x7f {
+			return '_'
+		}
+		return r
+	}, name)
+	return `attachment; filename="` + fallback + `"; filename*=UTF-8''` + extValueEscape(name)
+}
+
+// extValueEscape percent-encodes s as the value-chars of an RFC 5987
+// ext-value: every byte but an attr-char becomes %XX.
+func extValueEscape(s string) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if isAttrChar(c) {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hex[c>>4])
+		b.WriteByte(hex[c&0x0f])
+	}
+	return b.String()
+}
+
+// isAttrChar reports whether c is an RFC 5987 attr-char: a letter, digit
+// or one of !#$&+-.^_`|~.
+func isAttrChar(c byte) bool {
+	return 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+		strings.IndexByte("!#$&+-.^_`|~", c) >= 0
+}
+
+// serveDownload sends the photo's file untouched, as an attachment: no
+// transcoding or EXIF rotation, since the point is to get the original.
+func (c *imageCache) serveDownload(w http.ResponseWriter, r *http.Request, name, fullPath string, fi os.FileInfo) {
+	w.Header().Set("Content-Disposition", attachmentDisposition(path.Base(name)))
+	if ct := mime.TypeByExtension(strings.ToLower(filepath.Ext(name))); ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+	w.Header().Set("ETag", imageETag(fi, ""))
+	http.ServeFile(rangeGuard(w, fi.Size()), r, fullPath)
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestAttachmentDisposition(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"beach.jpg", `attachment; filename="beach.jpg"; filename*=UTF-8''beach.jpg`},
+		{"my photo (1).jpg", `attachment; filename="my photo (1).jpg"; filename*=UTF-8''my%20photo%20%281%29.jpg`},
+		{"Café.jpg", `attachment; filename="Caf_.jpg"; filename*=UTF-8''Caf%C3%A9.jpg`},
+		{"東京.png", `attachment; filename="__.png"; filename*=UTF-8''%E6%9D%B1%E4%BA%AC.png`},
+		{`say "hi".jpg`, `attachment; filename="say _hi_.jpg"; filename*=UTF-8''say%20%22hi%22.jpg`},
+		{`back\slash.jpg`, `attachment; filename="back_slash.jpg"; filename*=UTF-8''back%5Cslash.jpg`},
+		{"a'b%c;d.jpg", `attachment; filename="a'b%c;d.jpg"; filename*=UTF-8''a%27b%25c%3Bd.jpg`},
+		{"x!#$&+^_`|~.jpg", "attachment; filename=\"x!#$&+^_`|~.jpg\"; filename*=UTF-8''x!#$&+^_`|~.jpg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := attachmentDisposition(tt.name); got != tt.want {
+				t.Errorf("attachmentDisposition(%q)\n got %s\nwant %s", tt.name, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// ---- Live listing changes (/api/events) ----
+
+// PhotosChangedEvent is the data of a "photos-changed" event.
+type PhotosChangedEvent struct {
+	// Hash is the new listing hash, as in X-Photos-Hash.
+	Hash string `json:"hash"`
+}
+
+// eventsHandler streams a "photos-changed" event with the listing hash when
+// a client connects and whenever the listing changes afterwards. Each stream
+// waits on the index's change channel, so a client that goes away simply
+// stops waiting; there is nothing else to clean up.
+func eventsHandler(index *photoIndex) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		rc := startSSE(w)
+		heartbeat := time.NewTicker(sseHeartbeat)
+		defer heartbeat.Stop()
+
+		sent := ""
+		for {
+			_, hash, changed, _ := index.snapshot()
+			if hash != sent {
+				b, _ := json.Marshal(PhotosChangedEvent{Hash: hash})
+				if writeSSE(rc, w, "photos-changed", b) != nil {
+					return
+				}
+				sent = hash
+			}
+
+			select {
+			case <-changed:
+			case <-heartbeat.C:
+				if writeSSEPing(rc, w) != nil {
+					return
+				}
+			case <-r.Context().Done():
+				return
+			case <-shuttingDown:
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ---- Fallback for missing photos (FALLBACK_IMAGE) ----
+
+// fallbackImage stands in for photos that were deleted after a client
+// fetched the listing, so frames show a placeholder instead of a broken
+// image. Requests that could never name a photo (traversal, disallowed
+// extension) still get a real 404.
+type fallbackImage struct {
+	body        []byte
+	contentType string
+}
+
+// loadFallbackImage reads FALLBACK_IMAGE: "default" for the built-in
+// placeholder, otherwise the path of an image file.
+func loadFallbackImage(v string) (*fallbackImage, error) {
+	if strings.EqualFold(v, "default") {
+		b, err := staticFS.ReadFile("static/missing.svg")
+		if err != nil {
+			return nil, err
+		}
+		return &fallbackImage{body: b, contentType: "image/svg+xml"}, nil
+	}
+
+	b, err := os.ReadFile(v)
+	if err != nil {
+		return nil, err
+	}
+	ct := mime.TypeByExtension(strings.ToLower(filepath.Ext(v)))
+	if ct == "" {
+		ct = http.DetectContentType(b)
+	}
+	return &fallbackImage{body: b, contentType: ct}, nil
+}
+
+// serve answers with the placeholder and a 200. It must not be cached: the
+// URL may well point at a real photo again later.
+func (f *fallbackImage) serve(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", f.contentType)
+	w.Header().Set("Cache-Control", "no-store")
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(f.body))
+}
+
+// missingPhoto answers a request for a photo that isn't there. valid says
+// whether the name could be a photo at all; only then is the fallback used.
+func (c *imageCache) missingPhoto(w http.ResponseWriter, r *http.Request, valid bool) {
+	if valid && c.fallback != nil {
+		c.fallback.serve(w, r)
+		return
+	}
+	http.NotFound(w, r)
+}
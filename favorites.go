@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+)
+
+// ---- Favorites (FAVORITES_FILE) ----
+
+// favoriteStore keeps the set of favorite photo names and persists it as
+// JSON: {"favorites": ["dog.jpg", "vacation/beach.jpg"]}.
+type favoriteStore struct {
+	path string
+
+	mu    sync.Mutex
+	names map[string]bool
+}
+
+type favoritesFile struct {
+	Favorites []string `json:"favorites"`
+}
+
+// loadFavorites reads the store at path; a missing file is an empty store.
+func loadFavorites(path string) (*favoriteStore, error) {
+	s := &favoriteStore{path: path, names: make(map[string]bool)}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f favoritesFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, err
+	}
+	for _, n := range f.Favorites {
+		s.names[n] = true
+	}
+	return s, nil
+}
+
+func (s *favoriteStore) has(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.names[name]
+}
+
+// set marks or unmarks name and saves the file. The in-memory state only
+// changes if the save succeeds.
+func (s *favoriteStore) set(name string, favorite bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.names[name] == favorite {
+		return nil
+	}
+
+	f := favoritesFile{Favorites: []string{}}
+	for n := range s.names {
+		if n != name {
+			f.Favorites = append(f.Favorites, n)
+		}
+	}
+	if favorite {
+		f.Favorites = append(f.Favorites, name)
+	}
+	sort.Strings(f.Favorites)
+
+	if err := writeJSONAtomic(s.path, f); err != nil {
+		return err
+	}
+
+	if favorite {
+		s.names[name] = true
+	} else {
+		delete(s.names, name)
+	}
+	return nil
+}
+
+// markFavorites sets Favorite on the photos in store (which may be nil).
+// Favorites that have no weight of their own count as #fav for
+// order=weighted.
+func markFavorites(store *favoriteStore, photos []Photo) {
+	if store == nil {
+		return
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	for i := range photos {
+		if store.names[photos[i].Name] {
+			photos[i].Favorite = true
+			if photos[i].Weight <= 1 {
+				photos[i].Weight = favWeight
+			}
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ---- Field selection (/api/photos?fields=) ----
+
+// photoFields are the JSON names of Photo's fields, which is what ?fields=
+// accepts. Read off the struct tags so new fields can be picked right away.
+var photoFields = jsonFieldNames(reflect.TypeOf(Photo{}))
+
+func jsonFieldNames(t reflect.Type) map[string]bool {
+	names := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// parseFields reads a ?fields=url,name list. nil means every field.
+func parseFields(v string) (map[string]bool, error) {
+	if strings.TrimSpace(v) == "" {
+		return nil, nil
+	}
+	fields := make(map[string]bool)
+	for _, f := range strings.Split(v, ",") {
+		f = strings.ToLower(strings.TrimSpace(f))
+		if f == "" {
+			continue
+		}
+		if !photoFields[f] {
+			valid := make([]string, 0, len(photoFields))
+			for name := range photoFields {
+				valid = append(valid, name)
+			}
+			sort.Strings(valid)
+			return nil, fmt.Errorf("unknown field %q (valid: %s)", f, strings.Join(valid, ","))
+		}
+		fields[f] = true
+	}
+	return fields, nil
+}
+
+// projectPhoto is p with only the given fields, ready to encode as JSON.
+// Empty optional fields are still left out, as in the full listing.
+func projectPhoto(p Photo, fields map[string]bool) any {
+	if fields == nil {
+		return p
+	}
+	b, err := json.Marshal(p)
+	if err != nil {
+		return p
+	}
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(b, &m); err != nil {
+		return p
+	}
+	for k := range m {
+		if !fields[k] {
+			delete(m, k)
+		}
+	}
+	return m
+}
+
+// projectedPhotosResponse is a PhotosResponse whose photos carry only the
+// requested fields; its photos field takes precedence over the embedded one.
+type projectedPhotosResponse struct {
+	PhotosResponse
+	Photos []any `json:"photos"`
+}
+
+// projectResponse applies ?fields= to a listing response.
+func projectResponse(resp PhotosResponse, fields map[string]bool) any {
+	if fields == nil {
+		return resp
+	}
+	photos := make([]any, len(resp.Photos))
+	for i, p := range resp.Photos {
+		photos[i] = projectPhoto(p, fields)
+	}
+	return projectedPhotosResponse{PhotosResponse: resp, Photos: photos}
+}
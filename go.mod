@@ -1,3 +1,30 @@
 module frameserve
 
-go 1.22
+go 1.22.3
+
+require (
+	github.com/buckket/go-blurhash v1.1.0
+	github.com/fsnotify/fsnotify v1.9.0
+	github.com/gen2brain/avif v0.4.2
+	github.com/jdeng/goheif v0.1.2
+	github.com/prometheus/client_golang v1.20.5
+	github.com/rwcarlsen/goexif v0.0.0-20190401172101-9e8deecbddbd
+	golang.org/x/image v0.18.0
+	golang.org/x/net v0.35.0
+	golang.org/x/time v0.10.0
+)
+
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	github.com/ebitengine/purego v0.8.1 // indirect
+	github.com/klauspost/compress v1.17.9 // indirect
+	github.com/munnerz/goautoneg v0.0.0-20191010083416-a7dc8b61c822 // indirect
+	github.com/prometheus/client_model v0.6.1 // indirect
+	github.com/prometheus/common v0.55.0 // indirect
+	github.com/prometheus/procfs v0.15.1 // indirect
+	github.com/tetratelabs/wazero v1.8.1 // indirect
+	golang.org/x/sys v0.30.0 // indirect
+	golang.org/x/text v0.22.0 // indirect
+	google.golang.org/protobuf v1.34.2 // indirect
+)
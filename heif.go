@@ -0,0 +1,13 @@
+//go:build heif
+
+package main
+
+import "github.com/jdeng/goheif"
+
+// HEIC/HEIF decoding needs cgo (libde265), so it's opt-in:
+//
+//	CGO_ENABLED=1 go build -tags heif .
+func init() {
+	transcodeDecoders[".heic"] = goheif.Decode
+	transcodeDecoders[".heif"] = goheif.Decode
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ---- .frameserveignore ----
+
+// ignoreFileName, at the root of PHOTOS_DIR, lists gitignore-style patterns
+// for photos and (with RECURSIVE=true) folders to leave out:
+//
+//	# comments and blank lines are skipped
+//	*.tmp          any file or folder named like this, at any depth
+//	/drafts/       the drafts folder at the top only; "/" at the end = folders
+//	trips/**/raw   raw folders anywhere under trips
+//	!keep.tmp      a later "!" pattern brings a file back
+//
+// As in git, the last matching pattern wins, and nothing inside an ignored
+// folder can be brought back. The file is hidden, so it's never a photo.
+const ignoreFileName = ".frameserveignore"
+
+// maxIgnoreFileBytes bounds how much of the ignore file is read.
+const maxIgnoreFileBytes = 256 << 10
+
+type ignoreRule struct {
+	segs    []string // pattern split on "/"; "**" matches any number of them
+	negate  bool
+	dirOnly bool
+}
+
+// ignoreRules is a parsed ignore file; nil ignores nothing.
+type ignoreRules []ignoreRule
+
+// parseIgnoreRules reads gitignore syntax. Patterns path.Match can't parse
+// never match, rather than failing the scan.
+func parseIgnoreRules(r io.Reader) ignoreRules {
+	var rules ignoreRules
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimRight(strings.TrimPrefix(sc.Text(), "\ufeff"), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var rule ignoreRule
+		if rule.negate = strings.HasPrefix(line, "!"); rule.negate {
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+			line = line[1:]
+		}
+		if rule.dirOnly = strings.HasSuffix(line, "/"); rule.dirOnly {
+			line = strings.TrimRight(line, "/")
+		}
+		// A pattern with a slash is relative to the root; one without
+		// matches at any depth.
+		anchored := strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		if line == "" {
+			continue
+		}
+		if !anchored {
+			line = "**/" + line
+		}
+		rule.segs = strings.Split(line, "/")
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// ignored reports whether name (slash-separated, relative to the photos
+// folder) is left out, either itself or because a folder it's in is.
+func (rules ignoreRules) ignored(name string) bool {
+	if len(rules) == 0 {
+		return false
+	}
+	elems := strings.Split(name, "/")
+	for i := 1; i < len(elems); i++ {
+		if rules.match(elems[:i], true) {
+			return true
+		}
+	}
+	return rules.match(elems, false)
+}
+
+// match applies the rules to one path, without looking at its folders.
+func (rules ignoreRules) match(elems []string, isDir bool) bool {
+	ignored := false
+	for _, rule := range rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if matchSegments(rule.segs, elems) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// matchSegments matches path elements against pattern segments, where "**"
+// stands for zero or more elements and the rest use path.Match.
+func matchSegments(pat, elems []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			for i := 0; i <= len(elems); i++ {
+				if matchSegments(pat[1:], elems[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(elems) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pat[0], elems[0]); !ok {
+			return false
+		}
+		pat, elems = pat[1:], elems[1:]
+	}
+	return len(elems) == 0
+}
+
+// ignoreFileCache keeps each folder's parsed ignore file while its mtime
+// matches, so scans and photo requests only stat it.
+type ignoreFileCache struct {
+	mu      sync.Mutex
+	entries map[string]ignoreFileEntry
+}
+
+type ignoreFileEntry struct {
+	mtime int64 // unix nanoseconds
+	size  int64
+	rules ignoreRules
+}
+
+var photoIgnores = &ignoreFileCache{entries: make(map[string]ignoreFileEntry)}
+
+// load returns the rules from dir's ignore file, or nil without one.
+func (c *ignoreFileCache) load(dir string) ignoreRules {
+	p := filepath.Join(dir, ignoreFileName)
+	fi, err := os.Stat(p)
+	if err != nil || !fi.Mode().IsRegular() {
+		return nil
+	}
+	mtime := fi.ModTime().UnixNano()
+
+	c.mu.Lock()
+	e, ok := c.entries[p]
+	c.mu.Unlock()
+	if ok && e.mtime == mtime && e.size == fi.Size() {
+		return e.rules
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	rules := parseIgnoreRules(io.LimitReader(f, maxIgnoreFileBytes))
+
+	c.mu.Lock()
+	c.entries[p] = ignoreFileEntry{mtime: mtime, size: fi.Size(), rules: rules}
+	c.mu.Unlock()
+	return rules
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIgnoreRules(t *testing.T) {
+	rules := parseIgnoreRules(strings.NewReader(`
+# comment
+*.tmp
+/drafts/
+trips/**/raw
+!keep.tmp
+`))
+
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"a.jpg", false},
+		{"a.tmp", true},
+		{"vacation/2024/a.tmp", true},
+		{"keep.tmp", false},
+		{"vacation/keep.tmp", false},
+		{"drafts/a.jpg", true},
+		{"vacation/drafts/a.jpg", false}, // anchored to the top
+		{"drafts.jpg", false},
+		{"trips/raw/a.jpg", true},
+		{"trips/2024/italy/raw/a.jpg", true},
+		{"trips/2024/italy/a.jpg", false},
+		{"vacation/raw/a.jpg", false},
+	}
+	for _, tt := range tests {
+		if got := rules.ignored(tt.name); got != tt.want {
+			t.Errorf("ignored(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"io/fs"
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// ---- Photo index (in-memory listing) ----
+
+const (
+	// rescanDebounce coalesces bursts of events (an rsync, a bulk copy) into
+	// a single rescan.
+	rescanDebounce = 250 * time.Millisecond
+	// safetyRescanInterval catches anything fsnotify missed, e.g. on network
+	// mounts that don't deliver events.
+	safetyRescanInterval = time.Minute
+	// watchTimeout bounds how long /api/photos/watch holds a request.
+	watchTimeout = 30 * time.Second
+)
+
+// photoIndex keeps the photo listing in memory so requests don't rescan the
+// store. For a local folder it rescans when fsnotify reports a change, or
+// every pollInterval on platforms/filesystems where fsnotify isn't available
+// (and always for remote stores), and wakes anyone waiting on it whenever
+// the listing's stableHash changes.
+type photoIndex struct {
+	store        photoStore
+	pollInterval time.Duration
+
+	// scanMu serializes rescans so a slow one can't overwrite a newer result.
+	scanMu sync.Mutex
+
+	mu      sync.RWMutex
+	photos  []Photo
+	hash    string
+	err     error
+	changed chan struct{} // closed (and replaced) on every change
+
+	// lastScan, lastScanTook and scanErrs describe the latest rescan, for
+	// /api/stats.
+	lastScan     time.Time
+	lastScanTook time.Duration
+	scanErrs     *scanErrors
+}
+
+func newPhotoIndex(store photoStore, pollInterval time.Duration) *photoIndex {
+	return &photoIndex{
+		store:        store,
+		pollInterval: pollInterval,
+		changed:      make(chan struct{}),
+	}
+}
+
+// snapshot returns the current listing, its hash, a channel that is closed
+// the next time the listing changes, and the error from the latest scan.
+// The photos slice is shared and must not be modified.
+func (ix *photoIndex) snapshot() ([]Photo, string, <-chan struct{}, error) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return ix.photos, ix.hash, ix.changed, ix.err
+}
+
+// stats reports when the listing was last scanned, and which files it
+// couldn't read.
+func (ix *photoIndex) stats() StatsResponse {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	s := StatsResponse{
+		ScanDurationMS: ix.lastScanTook.Milliseconds(),
+		PhotoCount:     len(ix.photos),
+		ScanErrorCount: ix.scanErrs.count(),
+		ScanErrors:     ix.scanErrs.list(),
+	}
+	if !ix.lastScan.IsZero() {
+		s.LastScanUnix = ix.lastScan.Unix()
+	}
+	if ix.err != nil {
+		s.ScanFailed = ix.err.Error()
+	}
+	return s
+}
+
+// start performs the initial scan and keeps the index fresh in the
+// background.
+func (ix *photoIndex) start() {
+	ix.rescan()
+
+	local, ok := ix.store.(*localStore)
+	if !ok {
+		go ix.poll()
+		return
+	}
+
+	w, err := fsnotify.NewWatcher()
+	if err == nil {
+		err = w.Add(local.dir)
+		if err != nil {
+			w.Close()
+		}
+	}
+	if err != nil {
+		log.Printf("fsnotify unavailable (%v); rescanning every %s", err, ix.pollInterval)
+		go ix.poll()
+		return
+	}
+
+	ix.addDirs(w, local)
+	go ix.watch(w, local)
+}
+
+func (ix *photoIndex) poll() {
+	t := time.NewTicker(ix.pollInterval)
+	defer t.Stop()
+	for range t.C {
+		ix.rescan()
+	}
+}
+
+func (ix *photoIndex) watch(w *fsnotify.Watcher, local *localStore) {
+	defer w.Close()
+
+	safety := time.NewTicker(safetyRescanInterval)
+	defer safety.Stop()
+
+	// With PHOTO_MIN_AGE_SECONDS, files skipped as too new need another
+	// rescan once they are old enough.
+	var debounce, settle <-chan time.Time
+	for {
+		select {
+		case _, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			if debounce == nil {
+				debounce = time.After(rescanDebounce)
+			}
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			// Usually an event queue overflow: we may have missed something.
+			log.Printf("fsnotify error: %v", err)
+			if debounce == nil {
+				debounce = time.After(rescanDebounce)
+			}
+		case <-debounce:
+			debounce = nil
+			ix.rescan()
+			ix.addDirs(w, local)
+			if photoMinAge > 0 {
+				settle = time.After(photoMinAge)
+			}
+		case <-settle:
+			settle = nil
+			ix.rescan()
+		case <-safety.C:
+			ix.rescan()
+			ix.addDirs(w, local)
+		}
+	}
+}
+
+// addDirs (re)registers the directories to watch. fsnotify isn't recursive,
+// so with RECURSIVE=true every subdirectory needs its own watch; re-adding an
+// already watched directory is harmless.
+func (ix *photoIndex) addDirs(w *fsnotify.Watcher, local *localStore) {
+	if !local.recursive {
+		_ = w.Add(local.dir)
+		return
+	}
+	_ = filepath.WalkDir(local.dir, func(p string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() {
+			_ = w.Add(p)
+		}
+		return nil
+	})
+}
+
+func (ix *photoIndex) rescan() {
+	ix.scanMu.Lock()
+	defer ix.scanMu.Unlock()
+
+	start := time.Now()
+	photos, errs, err := scanPhotos(ix.store)
+	if err != nil {
+		log.Printf("scan error: %v", err)
+		photos = nil
+	}
+	// Only rescan sets ix.scanErrs, and under scanMu, so no lock is needed.
+	errs.logNew(ix.scanErrs)
+	took := time.Since(start)
+	scanDuration.Observe(took.Seconds())
+	photoCount.Set(float64(len(photos)))
+	scanErrorCount.Set(float64(errs.count()))
+	hash := stableHash(photos)
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.err = err
+	ix.lastScan = start.Add(took)
+	ix.lastScanTook = took
+	ix.scanErrs = errs
+	if hash == ix.hash {
+		return
+	}
+	ix.hash = hash
+	ix.photos = photos
+	close(ix.changed)
+	ix.changed = make(chan struct{})
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// ---- Logging ----
+
+// setupLogging switches the default logger to JSON when format is "json".
+// Existing log.Printf calls are routed through slog too, so every line is
+// structured. Anything else keeps the standard human-readable text output.
+// Messages below level are dropped.
+func setupLogging(format string, level slog.Level) {
+	if format == "json" {
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+		return
+	}
+	slog.SetLogLoggerLevel(level)
+}
+
+// loggingMiddleware emits one log line per request: Apache Common or
+// Combined Log Format on stdout for format "common"/"combined" (for log
+// analyzers like GoAccess), otherwise through slog. basicUser is
+// BASIC_AUTH_USER, the only username the access log will show.
+func loggingMiddleware(format, basicUser string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		if rec.status == http.StatusMethodNotAllowed {
+			// Usually a misconfigured client; say what it should have used.
+			slog.Debug("method not allowed",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"allow", rec.Header().Get("Allow"),
+				"client_ip", clientAddr(r),
+				"request_id", requestID(r.Context()),
+			)
+		}
+
+		switch format {
+		case "common", "combined":
+			writeAccessLog(os.Stdout, format == "combined", basicUser, r, rec, start)
+		default:
+			slog.Info("request",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"status", rec.status,
+				"duration_ms", time.Since(start).Milliseconds(),
+				"remote_addr", r.RemoteAddr,
+				"client_ip", clientAddr(r),
+				"request_id", requestID(r.Context()),
+			)
+		}
+	})
+}
+
+// writeAccessLog writes one Common Log Format line, with the referer and
+// user agent appended for Combined:
+//
+//	host - user [10/Oct/2000:13:55:36 -0700] "GET /a.jpg HTTP/1.1" 200 2326 "referer" "agent"
+//
+// Credentials stay out of the line: the user is only shown when it is
+// basicUser, since without BASIC_AUTH_USER any username works and clients
+// may put anything there, and credential query values are redacted.
+func writeAccessLog(out io.Writer, combined bool, basicUser string, r *http.Request, rec *statusRecorder, start time.Time) {
+	host := clientAddr(r)
+	user := "-"
+	if u, _, ok := r.BasicAuth(); ok && basicUser != "" && u == basicUser {
+		user = clfEscape(u)
+	}
+	size := "-"
+	if rec.bytes > 0 {
+		size = fmt.Sprint(rec.bytes)
+	}
+
+	line := fmt.Sprintf(`%s - %s [%s] "%s %s %s" %d %s`,
+		host, user, start.Format("02/Jan/2006:15:04:05 -0700"),
+		clfEscape(r.Method), clfEscape(redactRequestURI(r.RequestURI)), clfEscape(r.Proto), rec.status, size)
+	if combined {
+		line += fmt.Sprintf(` "%s" "%s"`, clfField(r.Referer()), clfField(r.UserAgent()))
+	}
+	io.WriteString(out, line+"\n")
+}
+
+// secretParams are the query parameters that carry credentials: the token
+// (?token= or ?t=) and share-link signatures (?sig=).
+var secretParams = map[string]bool{"token": true, "t": true, "sig": true}
+
+// redactRequestURI replaces the values of secretParams in uri with
+// "REDACTED", leaving everything else (including the parameter order) as
+// the client sent it.
+func redactRequestURI(uri string) string {
+	path, query, ok := strings.Cut(uri, "?")
+	if !ok {
+		return uri
+	}
+	params := strings.Split(query, "&")
+	for i, param := range params {
+		key, _, _ := strings.Cut(param, "=")
+		if k, err := url.QueryUnescape(key); err == nil && secretParams[k] {
+			params[i] = key + "=REDACTED"
+		}
+	}
+	return path + "?" + strings.Join(params, "&")
+}
+
+// clfField is a quoted log field: "-" when empty, escaped otherwise.
+func clfField(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return clfEscape(s)
+}
+
+// clfEscape escapes quotes, backslashes and control characters the way
+// Apache does, so a client can't break the line format.
+func clfEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&b, `\x%02x`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
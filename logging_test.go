@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteAccessLogHidesCredentials(t *testing.T) {
+	tests := []struct {
+		name      string
+		target    string
+		basicUser string
+		user      string // Basic Auth username sent, if any
+		wantURI   string
+		wantUser  string
+	}{
+		{name: "plain", target: "/photos/a.jpg?w=400", wantURI: "/photos/a.jpg?w=400", wantUser: "-"},
+		{name: "token", target: "/?token=s3cret&order=random", wantURI: "/?token=REDACTED&order=random", wantUser: "-"},
+		{name: "short token", target: "/api/photos?limit=5&t=s3cret", wantURI: "/api/photos?limit=5&t=REDACTED", wantUser: "-"},
+		{name: "share link", target: "/photos/a.jpg?exp=1700000000&sig=abc123", wantURI: "/photos/a.jpg?exp=1700000000&sig=REDACTED", wantUser: "-"},
+		{name: "escaped key", target: "/?%74oken=s3cret", wantURI: "/?%74oken=REDACTED", wantUser: "-"},
+		{name: "token as username", target: "/", user: "s3cret", wantURI: "/", wantUser: "-"},
+		{name: "wrong username", target: "/", basicUser: "frame", user: "s3cret", wantURI: "/", wantUser: "-"},
+		{name: "configured username", target: "/", basicUser: "frame", user: "frame", wantURI: "/", wantUser: "frame"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.target, nil)
+			if tt.user != "" {
+				r.SetBasicAuth(tt.user, "pw")
+			}
+			rec := &statusRecorder{status: 200}
+			var out bytes.Buffer
+			writeAccessLog(&out, false, tt.basicUser, r, rec, time.Now())
+			line := out.String()
+
+			if strings.Contains(line, "s3cret") || strings.Contains(line, "abc123") {
+				t.Errorf("credential logged: %s", line)
+			}
+			if want := `"GET ` + tt.wantURI + ` HTTP/1.1"`; !strings.Contains(line, want) {
+				t.Errorf("line %q lacks %s", line, want)
+			}
+			if fields := strings.Fields(line); len(fields) < 3 || fields[2] != tt.wantUser {
+				t.Errorf("user in %q, want %s", line, tt.wantUser)
+			}
+		})
+	}
+}
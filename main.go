@@ -1,24 +1,41 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
+	"crypto/tls"
 	"embed"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"io"
+	"io/fs"
 	"log"
+	"log/slog"
+	"math"
+	"math/rand"
 	"mime"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"path"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
-	"crypto/subtle"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 //go:embed static/*
@@ -29,23 +46,128 @@ type Photo struct {
 	Name  string `json:"name"`
 	Mtime int64  `json:"mtime"`
 	Size  int64  `json:"size"`
+	// Width and Height are the pixel dimensions from the image header; 0 when
+	// the format's header can't be read (e.g. HEIC).
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	// AspectRatio is width/height as displayed (EXIF quarter-turns taken
+	// into account), so clients can pick cover vs. contain; 0 when unknown.
+	AspectRatio float64 `json:"aspect_ratio"`
+	// Weight is how often order=weighted repeats the photo (default 1); see
+	// weights.go.
+	Weight int `json:"weight"`
+	// Favorite is set when a viewer starred the photo (FAVORITES_FILE).
+	Favorite bool `json:"favorite,omitempty"`
+	// ExifTime is the EXIF capture time (unix seconds), when the file has one.
+	ExifTime int64 `json:"exif_time,omitempty"`
+	// Blurhash is a tiny placeholder of the image (BLURHASH=true); it shows
+	// up a little after the photo itself, once computed.
+	Blurhash string `json:"blurhash,omitempty"`
+	// Caption comes from a sidecar file next to the photo (captions.go).
+	Caption string `json:"caption,omitempty"`
+	// Kind is "video" for clips (ALLOW_VIDEO=true), so clients use a <video>
+	// element, and "image" for everything else.
+	Kind string `json:"kind"`
 }
 
 type PhotosResponse struct {
 	Photos []Photo `json:"photos"`
 	Count  int     `json:"count"`
+	// Limit and Offset echo the requested page (?limit=&offset=), if any.
+	Limit  int `json:"limit,omitempty"`
+	Offset int `json:"offset,omitempty"`
+	// Hash identifies the directory listing; only set by /api/photos/watch.
+	Hash string `json:"hash,omitempty"`
+	// Duplicates lists the photos ?dedup=true left out.
+	Duplicates []Duplicate `json:"duplicates,omitempty"`
+}
+
+// ConfigResponse is the /api/config body: slideshow defaults set on the
+// server, which URL params still override.
+type ConfigResponse struct {
+	SlideIntervalMS int    `json:"slide_interval_ms"`
+	Transition      string `json:"transition"`
+	ShowCaptions    bool   `json:"show_captions"`
+	DefaultOrder    string `json:"default_order"`
+	// Preset holds the params of the ?preset= the config was asked for,
+	// for the slideshow to apply like URL params.
+	Preset map[string]string `json:"preset,omitempty"`
+}
+
+// RootStatus is what / answers clients that prefer JSON over the page.
+type RootStatus struct {
+	Name       string `json:"name"`
+	Version    string `json:"version"`
+	PhotoCount int    `json:"photo_count"`
+}
+
+// StatsResponse is the /api/stats body: how the in-memory listing was
+// last built.
+type StatsResponse struct {
+	// LastScanUnix is when the latest scan finished; 0 before the first.
+	LastScanUnix   int64 `json:"last_scan_unix"`
+	ScanDurationMS int64 `json:"scan_duration_ms"`
+	PhotoCount     int   `json:"photo_count"`
+	// ScanErrorCount is how many files the latest scan couldn't read;
+	// ScanErrors lists the first of them by name.
+	ScanErrorCount int         `json:"scan_error_count"`
+	ScanErrors     []ScanError `json:"scan_errors,omitempty"`
+	// ScanFailed is why the latest scan failed as a whole (the folder or
+	// bucket couldn't be listed), if it did.
+	ScanFailed string `json:"scan_failed,omitempty"`
+}
+
+// ReadyResponse is the /readyz body.
+type ReadyResponse struct {
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+	Photos int    `json:"photos,omitempty"`
 }
 
 const (
-	authCookieName = "frameserve_auth"
+	defaultCookieName = "frameserve_auth"
 	// 365 days. “Set it and forget it” while still having *some* bounded lifetime.
-	authCookieMaxAgeSeconds = 365 * 24 * 60 * 60
+	defaultCookieMaxAgeSeconds = 365 * 24 * 60 * 60
 )
 
 func main() {
+	// LOG_FORMAT=json emits structured JSON logs; default is plain text.
+	// "common" and "combined" write the per-request lines to stdout in the
+	// Apache formats instead (other messages stay plain text on stderr).
+	// LOG_LEVEL=debug adds diagnostics such as requests with the wrong
+	// method.
+	logFormat := strings.ToLower(getenv("LOG_FORMAT", "text"))
+	logLevel := slog.LevelInfo
+	logLevelErr := logLevel.UnmarshalText([]byte(getenv("LOG_LEVEL", "info")))
+	if logLevelErr != nil {
+		logLevel = slog.LevelInfo
+	}
+	setupLogging(logFormat, logLevel)
+	if logLevelErr != nil {
+		log.Printf("invalid LOG_LEVEL=%q, using info", os.Getenv("LOG_LEVEL"))
+	}
+
 	port := getenv("PORT", "80")
+	// BIND_ADDR limits listening to one interface, e.g. 127.0.0.1 behind a
+	// local proxy; empty listens on all of them.
+	bindAddr := getenv("BIND_ADDR", "")
+
+	// TLS_CERT + TLS_KEY (PEM files) serve HTTPS directly, no proxy needed.
+	tlsCert := getenv("TLS_CERT", "")
+	tlsKey := getenv("TLS_KEY", "")
+	// H2C=true accepts cleartext HTTP/2 ("h2c", prior knowledge or Upgrade)
+	// for a proxy that talks h2c to its backends, e.g. Caddy or Envoy.
+	// Browsers never speak h2c, and with TLS_CERT/TLS_KEY HTTP/2 is already
+	// negotiated over TLS, so it's ignored then. h2c connections are hijacked
+	// from net/http: READ_TIMEOUT and WRITE_TIMEOUT don't apply to them, and
+	// shutdown doesn't wait for their in-flight requests.
+	h2cEnabled := getenvBool("H2C", false)
 	photosDir := getenv("PHOTOS_DIR", "/photos")
 
+	// STORAGE=s3 reads photos from an S3-compatible bucket (AWS, MinIO, …)
+	// instead of PHOTOS_DIR, configured by the S3_* variables below.
+	storage := strings.ToLower(getenv("STORAGE", "local"))
+
 	// If AUTH_TOKEN is set, we enable auth for everything except /healthz.
 	// Flow:
 	//  - First visit: /?token=YOURTOKEN (or any path with token=...)
@@ -54,14 +176,411 @@ func main() {
 	//
 	// Also supports:
 	//  - Authorization: Bearer YOURTOKEN
-	authToken := strings.TrimSpace(os.Getenv("AUTH_TOKEN"))
+	//  - X-Auth-Token: YOURTOKEN
+	//  - Authorization: Basic (any user, or BASIC_AUTH_USER; password YOURTOKEN)
+	//
+	// AUTH_TOKENS accepts a comma-separated list so each person can get (and
+	// lose) their own token; it combines with AUTH_TOKEN.
+	var authTokens []string
+	if t := strings.TrimSpace(os.Getenv("AUTH_TOKEN")); t != "" {
+		authTokens = append(authTokens, t)
+	}
+	authTokens = append(authTokens, parseTokenList(os.Getenv("AUTH_TOKENS"))...)
+
+	// Share links (/api/photos/<name>/share) are signed with the first
+	// token, so rotating it invalidates every outstanding link.
+	var shareKey []byte
+	if len(authTokens) > 0 {
+		shareKey = []byte(authTokens[0])
+	}
+
+	// PRESET_KEY signs slideshow presets (/api/preset); by default the
+	// first token does, so presets made before it changes stop working.
+	presetKey := []byte(defaultPresetKey)
+	if v := os.Getenv("PRESET_KEY"); v != "" {
+		presetKey = []byte(v)
+	} else if len(shareKey) > 0 {
+		presetKey = shareKey
+	}
+
+	// HTTP Basic Auth is accepted too, with a token as the password.
+	// BASIC_AUTH_USER pins the username; by default any username works.
+	basicAuthUser := strings.TrimSpace(os.Getenv("BASIC_AUTH_USER"))
+
+	// COOKIE_NAME keeps two instances on one domain from sharing (and
+	// clobbering) a cookie; COOKIE_MAX_AGE_SECONDS shortens sessions, e.g.
+	// for a public display.
+	cookieName := getenv("COOKIE_NAME", defaultCookieName)
+	if !validCookieName(cookieName) {
+		log.Fatalf("invalid COOKIE_NAME=%q (letters, digits and -_. only)", cookieName)
+	}
+	cookieMaxAge := defaultCookieMaxAgeSeconds
+	if v := strings.TrimSpace(os.Getenv("COOKIE_MAX_AGE_SECONDS")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			log.Fatalf("invalid COOKIE_MAX_AGE_SECONDS=%q (want a positive number of seconds)", v)
+		}
+		cookieMaxAge = n
+	}
+	// COOKIE_DOMAIN shares the cookie with subdomains (e.g. example.com
+	// for frames.example.com and photos.example.com); COOKIE_SAMESITE=none
+	// lets it through in cross-site iframes, which also makes it Secure.
+	cookieDomain := strings.TrimSpace(os.Getenv("COOKIE_DOMAIN"))
+	if cookieDomain != "" && !validCookieDomain(cookieDomain) {
+		log.Fatalf("invalid COOKIE_DOMAIN=%q (want a host name like example.com)", cookieDomain)
+	}
+	cookieSameSite, err := parseSameSite(os.Getenv("COOKIE_SAMESITE"))
+	if err != nil {
+		log.Fatalf("invalid COOKIE_SAMESITE=%v", err)
+	}
+
+	// RECURSIVE=true walks subdirectories (albums) and exposes photos by their
+	// slash-separated path relative to PHOTOS_DIR, e.g. "vacation/beach.jpg".
+	recursive := getenvBool("RECURSIVE", false)
+
+	// PRETTY_JSON=false sends compact JSON from the API, which is about
+	// half the size for large listings.
+	prettyJSON = getenvBool("PRETTY_JSON", true)
+
+	// SYMLINK_POLICY decides what symlinks in PHOTOS_DIR may do: "reject"
+	// (the default) serves them only while they point inside the folder,
+	// "follow" serves them wherever they point and scans linked folders,
+	// "skip" ignores them.
+	symlinkPolicy = strings.ToLower(getenv("SYMLINK_POLICY", symlinkReject))
+	if symlinkPolicy != symlinkReject && symlinkPolicy != symlinkFollow && symlinkPolicy != symlinkSkip {
+		log.Fatalf("invalid SYMLINK_POLICY=%q (want reject, follow or skip)", os.Getenv("SYMLINK_POLICY"))
+	}
+
+	// CASE_INSENSITIVE=true makes /photos/ fall back to a name that
+	// differs only in case when there's no exact match.
+	caseInsensitive = getenvBool("CASE_INSENSITIVE", false)
+
+	// SCAN_WORKERS is how many files a scan stats in parallel; raise it
+	// for photos on a slow network mount.
+	scanWorkers = getenvInt("SCAN_WORKERS", scanWorkers)
+
+	// REDIRECT_HTTPS=true sends plain-http visitors (except /healthz) to the
+	// https:// URL; X-Forwarded-Proto is honored behind reverse proxies.
+	redirectHTTPS := getenvBool("REDIRECT_HTTPS", false)
+
+	// CORS_ORIGINS="https://dash.example.com,..." lets pages on those origins
+	// call /api/ from the browser ("*" allows any origin, without cookies).
+	// Unset means no CORS headers at all.
+	corsOrigins := parseOriginList(os.Getenv("CORS_ORIGINS"))
+
+	// CSP replaces the whole Content-Security-Policy, e.g. to allow an
+	// analytics script. FRAME_OPTIONS=SAMEORIGIN|none relaxes the default
+	// X-Frame-Options: DENY so the frame can sit in an iframe.
+	csp := getenv("CSP", defaultCSP)
+
+	// APP_NAME and THEME_COLOR go into /manifest.json, i.e. the name and
+	// title bar color of the installed app.
+	appName := getenv("APP_NAME", "Frameserve")
+	themeColor := getenv("THEME_COLOR", "#000000")
+	frameOptions, ok := parseFrameOptions(getenv("FRAME_OPTIONS", "DENY"))
+	if !ok {
+		log.Printf("invalid FRAME_OPTIONS=%q, using DENY", os.Getenv("FRAME_OPTIONS"))
+		frameOptions = "DENY"
+	}
+
+	// TRUSTED_CIDRS="192.168.1.0/24" lets clients on those networks in
+	// without a token. Behind a reverse proxy every request comes from the
+	// proxy, so set TRUST_PROXY=true to judge by X-Forwarded-For instead.
+	trustedNets, err := parseCIDRList(getenv("TRUSTED_CIDRS", ""))
+	if err != nil {
+		log.Fatalf("invalid TRUSTED_CIDRS: %v", err)
+	}
+	trustProxy = getenvBool("TRUST_PROXY", false)
+	// TRUSTED_PROXIES lists proxies in front of the one we talk to (a CDN,
+	// a load balancer), whose X-Forwarded-For hops are skipped as well.
+	trustedProxies, err = parseCIDRList(getenv("TRUSTED_PROXIES", ""))
+	if err != nil {
+		log.Fatalf("invalid TRUSTED_PROXIES: %v", err)
+	}
+
+	// METRICS_AUTH=true puts /metrics behind the token like everything else.
+	metricsAuth := getenvBool("METRICS_AUTH", false)
+
+	// AUTH_EXEMPT_PATHS adds paths served without a token, comma-separated;
+	// one ending in "/" is a prefix. /healthz, /readyz and /version are
+	// always exempt.
+	authExempt, err := parseExemptPaths(os.Getenv("AUTH_EXEMPT_PATHS"))
+	if err != nil {
+		log.Fatalf("invalid AUTH_EXEMPT_PATHS: %v", err)
+	}
+	for _, p := range authExempt {
+		if exposesContent(p) {
+			log.Printf("AUTH_EXEMPT_PATHS=%q exempts %s: photos are served without a token", os.Getenv("AUTH_EXEMPT_PATHS"), p)
+		}
+	}
+
+	// ALLOW_DELETE=true enables DELETE /photos/<name> (only with auth on).
+	allowDelete := getenvBool("ALLOW_DELETE", false)
+
+	// ALLOW_EDIT=true enables POST /api/photos/<name>/rotate, which rewrites
+	// the photo file (only with auth on).
+	allowEdit := getenvBool("ALLOW_EDIT", false)
+
+	// ALLOW_UPLOAD=true enables POST /api/upload (only with auth on), capped
+	// at MAX_UPLOAD_BYTES per request.
+	allowUpload := getenvBool("ALLOW_UPLOAD", false)
+	maxUploadBytes := int64(50 << 20)
+	if v := getenv("MAX_UPLOAD_BYTES", ""); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > 0 {
+			maxUploadBytes = n
+		} else {
+			log.Printf("invalid MAX_UPLOAD_BYTES=%q, using %d", v, maxUploadBytes)
+		}
+	}
+
+	// Photo and thumbnail URLs carry ?v=<mtime>, so by default browsers may
+	// keep them for a year. PHOTO_CACHE_MAXAGE (seconds) shortens that, e.g.
+	// while editing files in place; below a day "immutable" is dropped so
+	// reloads revalidate.
+	photoCacheMaxAge := getenvInt("PHOTO_CACHE_MAXAGE", 31536000)
+	photoCacheControl := fmt.Sprintf("public, max-age=%d", photoCacheMaxAge)
+	if photoCacheMaxAge >= 86400 {
+		photoCacheControl += ", immutable"
+	}
+
+	// PHOTO_MIN_AGE_SECONDS=10 keeps files out of the listing (and /photos/
+	// answers 503) until they are that old, for folders that are filled by
+	// slow copies that write in place.
+	photoMinAge = time.Duration(getenvInt("PHOTO_MIN_AGE_SECONDS", 0)) * time.Second
+
+	// MAX_PHOTO_BYTES=104857600 ignores files over 100 MB (0 = no limit).
+	maxPhotoBytes = int64(getenvInt("MAX_PHOTO_BYTES", 0))
+
+	// Rescan interval used only where fsnotify isn't available.
+	scanInterval := getenvDuration("SCAN_INTERVAL", 5*time.Second)
+
+	// SLIDESHOW_INTERVAL=30s runs one shared slideshow on the server that
+	// frames opened with ?sync=1 follow, so they all show the same photo.
+	slideshowInterval := getenvDuration("SLIDESHOW_INTERVAL", 0)
+
+	// CAST_WEBHOOK=http://receiver/show gets a JSON POST with the photo's
+	// URL every time that slideshow moves on. CAST_BASE_URL is this
+	// server's address as the receiver sees it, to make those URLs absolute.
+	castWebhookURL := getenv("CAST_WEBHOOK", "")
+	castBaseURL := getenv("CAST_BASE_URL", "")
+	if castWebhookURL != "" && slideshowInterval == 0 {
+		log.Printf("CAST_WEBHOOK ignored: it needs SLIDESHOW_INTERVAL")
+		castWebhookURL = ""
+	}
+
+	// READ_TIMEOUT caps reading a whole request, body included (0 = no
+	// limit, so slow uploads aren't cut off); READ_HEADER_TIMEOUT only the
+	// headers. WRITE_TIMEOUT caps how long a response may take to send (long
+	// enough for the 30s long-poll and a big GIF on Wi-Fi); IDLE_TIMEOUT
+	// closes idle keep-alive connections.
+	readTimeout := getenvDuration("READ_TIMEOUT", 0)
+	readHeaderTimeout := getenvDuration("READ_HEADER_TIMEOUT", 5*time.Second)
+	writeTimeout := getenvDuration("WRITE_TIMEOUT", 2*time.Minute)
+	idleTimeout := getenvDuration("IDLE_TIMEOUT", 2*time.Minute)
+	if readTimeout > 0 && readHeaderTimeout > readTimeout {
+		log.Printf("READ_HEADER_TIMEOUT=%s is longer than READ_TIMEOUT=%s; using %s", readHeaderTimeout, readTimeout, readTimeout)
+		readHeaderTimeout = readTimeout
+	}
+	if writeTimeout > 0 && writeTimeout <= watchTimeout {
+		log.Printf("WRITE_TIMEOUT=%s is shorter than the %s long-poll; /api/photos/watch will be cut off", writeTimeout, watchTimeout)
+	}
+
+	// IMAGE_TIMEOUT bounds thumbnailing/transcoding a single photo; slower
+	// ones (corrupt file, stalled SD card) get a 504.
+	imageTimeout := getenvDuration("IMAGE_TIMEOUT", 30*time.Second)
+
+	// TRANSCODE_CONCURRENCY caps how many images are decoded at once (0 = no
+	// cap), so a burst of cold-cache requests can't run a small device out
+	// of memory. Requests queue for up to TRANSCODE_QUEUE_TIMEOUT, then get
+	// a 503.
+	transcodeConcurrency := getenvInt("TRANSCODE_CONCURRENCY", runtime.GOMAXPROCS(0))
+	transcodeQueueTimeout := getenvDuration("TRANSCODE_QUEUE_TIMEOUT", 10*time.Second)
+
+	// FAVORITES_FILE enables POST/DELETE /api/photos/<name>/favorite and
+	// keeps the favorites there (JSON). It must be writable, so it can't
+	// live in a read-only photos mount.
+	favoritesFile := getenv("FAVORITES_FILE", "")
+
+	// FALLBACK_IMAGE=default (built-in placeholder) or a path to an image is
+	// served with a 200 for photos that have gone missing, so a frame with a
+	// stale listing shows that instead of a broken image.
+	fallbackImagePath := getenv("FALLBACK_IMAGE", "")
+
+	// How long to let in-flight requests drain on SIGINT/SIGTERM.
+	shutdownTimeout := getenvDuration("SHUTDOWN_TIMEOUT", 10*time.Second)
+
+	// Generated thumbnails and transcodes are cached so each variant is only
+	// produced once: in THUMB_CACHE_DIR by default, THUMB_CACHE=memory keeps
+	// up to THUMB_CACHE_MB of them in RAM instead (for read-only disks), and
+	// THUMB_CACHE=none regenerates them every time.
+	thumbCacheDir := getenv("THUMB_CACHE_DIR", filepath.Join(os.TempDir(), "frameserve-thumbs"))
+	thumbCacheMB := getenvInt("THUMB_CACHE_MB", 64)
+	derived, err := newDerivedCache(strings.ToLower(getenv("THUMB_CACHE", "disk")), thumbCacheDir, int64(thumbCacheMB)<<20)
+	if err != nil {
+		log.Fatalf("invalid THUMB_CACHE: %v", err)
+	}
+
+	// THUMB_QUALITY (1-100) trades the size of thumbnails and transcoded
+	// photos against their quality.
+	if q := getenvInt("THUMB_QUALITY", thumbQuality); q < 1 || q > 100 {
+		thumbQuality = min(max(q, 1), 100)
+		log.Printf("THUMB_QUALITY=%d is out of range (1-100); using %d", q, thumbQuality)
+	} else {
+		thumbQuality = q
+	}
+
+	// AUTO_ORIENT=true serves JPEGs rotated/flipped upright according to their
+	// EXIF Orientation tag (re-encoded once and cached), for browsers that
+	// ignore the tag.
+	autoOrient := getenvBool("AUTO_ORIENT", false)
+
+	// BLURHASH=true adds a "blurhash" placeholder to each photo in the
+	// listing. Each image is decoded once in the background, which costs CPU
+	// on a large library's first run.
+	useBlurhash := getenvBool("BLURHASH", false)
+
+	// ALLOWED_EXTENSIONS=".jpg,.png,.bmp" replaces the default allowlist.
+	if v := getenv("ALLOWED_EXTENSIONS", ""); v != "" {
+		if exts := parseExtList(v); len(exts) > 0 {
+			allowedExts = exts
+		} else {
+			log.Printf("ALLOWED_EXTENSIONS=%q has no usable entries; keeping defaults", v)
+		}
+	}
+
+	// ALLOW_VIDEO=true also serves .mp4 and .webm clips, which the
+	// slideshow plays through before moving on.
+	if getenvBool("ALLOW_VIDEO", false) {
+		enableVideo()
+	}
+
+	// DEFAULT_ORDER=name_asc changes the order used when a client doesn't
+	// ask for one.
+	if v := strings.TrimSpace(getenv("DEFAULT_ORDER", "")); v != "" {
+		if slices.Contains(sortOrders, v) {
+			defaultOrder = v
+		} else {
+			log.Printf("invalid DEFAULT_ORDER=%q, using %s", v, defaultOrder)
+		}
+	}
+
+	// SMART_HALFLIFE_DAYS=90 makes order=smart favor recent photos less: a
+	// photo that much older comes up half as often.
+	if days := getenvInt("SMART_HALFLIFE_DAYS", 30); days > 0 {
+		smartHalfLife = time.Duration(days) * 24 * time.Hour
+	} else {
+		log.Printf("invalid SMART_HALFLIFE_DAYS=0, using 30")
+	}
+
+	// Slideshow defaults handed to the frontend via /api/config, so each
+	// deployment can pick them without per-device URLs:
+	// SLIDE_INTERVAL_MS (time per photo), TRANSITION (fade|none) and
+	// SHOW_CAPTIONS (photo name overlay).
+	slideConfig := ConfigResponse{
+		SlideIntervalMS: min(max(getenvInt("SLIDE_INTERVAL_MS", 10000), 1000), 3600000),
+		Transition:      strings.ToLower(getenv("TRANSITION", "fade")),
+		ShowCaptions:    getenvBool("SHOW_CAPTIONS", false),
+	}
+	if slideConfig.Transition != "fade" && slideConfig.Transition != "none" {
+		log.Printf("invalid TRANSITION=%q, using fade", slideConfig.Transition)
+		slideConfig.Transition = "fade"
+	}
+
+	// IGNORE_PATTERNS="*_edit.jpg,Originals" skips files and folders whose
+	// name matches any glob (hidden dotfiles are always skipped).
+	if v := getenv("IGNORE_PATTERNS", ""); v != "" {
+		patterns, err := parseIgnorePatterns(v)
+		if err != nil {
+			log.Fatalf("invalid IGNORE_PATTERNS: %v", err)
+		}
+		ignorePatterns = patterns
+	}
+
+	// CUSTOM_STATIC_DIR=/theme serves index.html, info.html, app.js, … from
+	// that folder instead of the built-in copies; files it doesn't have fall
+	// back to the built-in ones, so it can hold just a logo. ASSET_OVERLAY_DIR
+	// is another name for it.
+	staticDirVar := "CUSTOM_STATIC_DIR"
+	if getenv(staticDirVar, "") == "" && getenv("ASSET_OVERLAY_DIR", "") != "" {
+		staticDirVar = "ASSET_OVERLAY_DIR"
+	}
+	if v := getenv(staticDirVar, ""); v != "" {
+		if fi, err := os.Stat(v); err != nil || !fi.IsDir() {
+			log.Fatalf("%s=%q is not a directory", staticDirVar, v)
+		}
+		customStaticDir = v
+	}
 
 	absPhotosDir, err := filepath.Abs(photosDir)
 	if err != nil {
 		log.Fatalf("failed to resolve PHOTOS_DIR: %v", err)
 	}
 
-	log.Printf("Frameserve starting: port=%s photos_dir=%s auth=%v", port, absPhotosDir, authToken != "")
+	var store photoStore = &localStore{dir: absPhotosDir, recursive: recursive}
+	switch storage {
+	case "local":
+	case "s3":
+		// Credentials fall back to the standard AWS variables.
+		accessKey := getenv("S3_ACCESS_KEY", os.Getenv("AWS_ACCESS_KEY_ID"))
+		secretKey := getenv("S3_SECRET_KEY", os.Getenv("AWS_SECRET_ACCESS_KEY"))
+		s3, err := newS3Store(
+			getenv("S3_ENDPOINT", "https://s3.amazonaws.com"),
+			getenv("S3_BUCKET", ""),
+			getenv("S3_PREFIX", ""),
+			getenv("S3_REGION", "us-east-1"),
+			accessKey, secretKey, recursive,
+		)
+		if err != nil {
+			log.Fatalf("invalid S3 storage settings: %v", err)
+		}
+		store = s3
+	default:
+		log.Fatalf("invalid STORAGE=%q (want local or s3)", storage)
+	}
+	_, isLocal := store.(*localStore)
+
+	build := buildInfo()
+	log.Printf("Frameserve %s (commit %s, built %s, %s)", build.Version, build.Commit, build.BuildDate, build.GoVersion)
+	if isLocal {
+		log.Printf("Frameserve starting: port=%s photos_dir=%s auth=%v recursive=%v", port, absPhotosDir, len(authTokens) > 0, recursive)
+	} else {
+		log.Printf("Frameserve starting: port=%s storage=%s auth=%v recursive=%v", port, storage, len(authTokens) > 0, recursive)
+	}
+
+	// Features that write to the photos folder or read every file need it on
+	// local disk.
+	if !isLocal {
+		if allowDelete || allowEdit || allowUpload {
+			log.Printf("ALLOW_DELETE, ALLOW_EDIT and ALLOW_UPLOAD are ignored with STORAGE=%s", storage)
+			allowDelete, allowEdit, allowUpload = false, false, false
+		}
+		if useBlurhash {
+			log.Printf("BLURHASH=true ignored with STORAGE=%s", storage)
+			useBlurhash = false
+		}
+	}
+
+	// Never expose unauthenticated endpoints that change the photos folder.
+	if allowDelete && len(authTokens) == 0 {
+		log.Printf("ALLOW_DELETE=true ignored: it requires AUTH_TOKEN or AUTH_TOKENS")
+		allowDelete = false
+	}
+	if allowEdit && len(authTokens) == 0 {
+		log.Printf("ALLOW_EDIT=true ignored: it requires AUTH_TOKEN or AUTH_TOKENS")
+		allowEdit = false
+	}
+	if allowUpload && len(authTokens) == 0 {
+		log.Printf("ALLOW_UPLOAD=true ignored: it requires AUTH_TOKEN or AUTH_TOKENS")
+		allowUpload = false
+	}
+
+	// Listing served from memory; rescanned when the directory changes.
+	index := newPhotoIndex(store, scanInterval)
+	if useBlurhash {
+		// Rescan once hashes are ready so they reach the listing (and watchers).
+		blurhashes = newBlurhashCache(index.rescan)
+	}
+	index.start()
 
 	mux := http.NewServeMux()
 
@@ -71,6 +590,31 @@ func main() {
 			http.NotFound(w, r)
 			return
 		}
+		// ?preset= expands into the params it stands for; any given next
+		// to it win.
+		if q := r.URL.Query(); q.Has("preset") {
+			params, err := decodePreset(presetKey, q.Get("preset"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			q.Del("preset")
+			for k, v := range q {
+				params[k] = v
+			}
+			w.Header().Set("Cache-Control", "no-store")
+			http.Redirect(w, r, "/?"+params.Encode(), http.StatusFound)
+			return
+		}
+		// curl and monitoring asking for JSON get a short status instead
+		// of the page.
+		w.Header().Add("Vary", "Accept")
+		if prefersJSON(r.Header.Get("Accept")) {
+			photos, _, _, _ := index.snapshot()
+			w.Header().Set("Cache-Control", "no-cache")
+			writeJSON(w, RootStatus{Name: appName, Version: build.Version, PhotoCount: len(photos)})
+			return
+		}
 		serveEmbeddedFile(w, r, "static/index.html", "text/html; charset=utf-8")
 	})
 
@@ -88,6 +632,13 @@ func main() {
 		serveEmbeddedFile(w, r, "static/info.html", "text/html; charset=utf-8")
 	})
 
+	// Installable app: manifest and service worker. The worker lives at the
+	// root so its scope covers the whole site.
+	mux.HandleFunc("/manifest.json", serveManifest(newWebManifest(appName, themeColor)))
+	mux.HandleFunc("/sw.js", func(w http.ResponseWriter, r *http.Request) {
+		serveEmbeddedFile(w, r, "static/sw.js", "text/javascript; charset=utf-8")
+	})
+
 	// Static assets
 	mux.HandleFunc("/static/", func(w http.ResponseWriter, r *http.Request) {
 		// Prevent directory listing; only serve embedded files
@@ -99,241 +650,1676 @@ func main() {
 		serveEmbeddedFile(w, r, path, "")
 	})
 
+	var favorites *favoriteStore
+	if favoritesFile != "" {
+		if favorites, err = loadFavorites(favoritesFile); err != nil {
+			log.Fatalf("failed to load FAVORITES_FILE: %v", err)
+		}
+	}
+
+	var fallback *fallbackImage
+	if fallbackImagePath != "" {
+		if fallback, err = loadFallbackImage(fallbackImagePath); err != nil {
+			log.Fatalf("failed to load FALLBACK_IMAGE: %v", err)
+		}
+	}
+
 	// API: list photos
+	// HEAD gets the same headers (ETag, X-Photos-Hash) without the body, for
+	// cheap change detection.
 	mux.HandleFunc("/api/photos", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		q := r.URL.Query()
+
+		// Optional: ?fields=url,name sends only those fields of each photo.
+		fields, err := parseFields(q.Get("fields"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Optional: ?stream=true writes the listing straight from the
+		// directory walk in constant memory (unsorted, unpaged).
+		if stream, _ := strconv.ParseBool(q.Get("stream")); stream {
+			if k := streamConflict(q); k != "" {
+				http.Error(w, k+" can't be combined with stream=true", http.StatusBadRequest)
+				return
+			}
+			if r.Method == http.MethodHead {
+				w.Header().Set("Content-Type", "application/json; charset=utf-8")
+				return
+			}
+			streamPhotos(w, r, store, favorites, fields)
+			return
+		}
+
+		photos, hash, _, err := index.snapshot()
+		if err != nil {
+			http.Error(w, "failed to scan photos directory", http.StatusInternalServerError)
+			return
+		}
+		// The index's slice is shared; sort a copy.
+		photos = append([]Photo(nil), photos...)
+		markFavorites(favorites, photos)
+
+		// Optional album filter: ?album=<subdirectory of PHOTOS_DIR>. The
+		// album's .order file, if any, replaces the default order.
+		order := q.Get("order")
+		if album := q.Get("album"); album != "" {
+			var ok bool
+			photos, ok = storeAlbumPhotos(store, album, photos)
+			if !ok {
+				http.Error(w, "album not found", http.StatusNotFound)
+				return
+			}
+			if order == "" {
+				order = storeAlbumOrder(store, album)
+			}
+		}
+
+		// Optional incremental sync: ?since=<unix seconds> keeps only photos
+		// modified after that time.
+		if v := q.Get("since"); v != "" {
+			since, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				http.Error(w, "since must be a unix timestamp in seconds", http.StatusBadRequest)
+				return
+			}
+			newer := photos[:0]
+			for _, p := range photos {
+				if p.Mtime > since {
+					newer = append(newer, p)
+				}
+			}
+			photos = newer
+		}
+
+		// Optional: ?maxpixels=N hides photos larger than N pixels (width ×
+		// height) for frames that can't cope with huge scans.
+		if v := q.Get("maxpixels"); v != "" {
+			maxPixels, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || maxPixels <= 0 {
+				http.Error(w, "maxpixels must be a positive integer", http.StatusBadRequest)
+				return
+			}
+			photos = limitPixels(photos, maxPixels)
+		}
+
+		// Optional: ?dedup=true drops byte-identical copies (keeping the
+		// oldest) and reports them in "duplicates".
+		var dupes []Duplicate
+		if dedup, _ := strconv.ParseBool(q.Get("dedup")); dedup {
+			if !isLocal {
+				http.Error(w, "dedup needs STORAGE=local", http.StatusBadRequest)
+				return
+			}
+			photos, dupes = dedupPhotos(absPhotosDir, photos)
+		}
+
+		// Optional paging: ?limit=N&offset=M (default: everything)
+		limit, offset, err := parsePage(q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Optional ordering controls via query params:
+		// ?order=mtime_desc|mtime_asc|name_asc|name_desc|exif_asc|exif_desc|random|shuffle_daily|weighted|smart|album_balanced (default mtime_desc)
+		// Sorting happens before paging so pages are stable.
+		photos = sortPhotos(photos, order)
+
+		// Clients poll this endpoint, so let them revalidate cheaply.
+		etag := photosETag(q.Encode(), photos)
+		w.Header().Set("ETag", etag)
+		// The same listing hash /api/photos/watch uses (whole directory).
+		w.Header().Set("X-Photos-Hash", hash)
+		w.Header().Set("Cache-Control", "no-cache")
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		total := len(photos)
+		photos = photos[min(offset, total):]
+		if limit > 0 && limit < len(photos) {
+			photos = photos[:limit]
+		}
+
+		writeJSON(w, projectResponse(PhotosResponse{Photos: photos, Count: total, Limit: limit, Offset: offset, Duplicates: dupes}, fields))
+	})
+
+	// API: long-poll for listing changes.
+	// ?hash=<last seen hash> blocks until the listing differs (or a timeout
+	// passes) and then responds like /api/photos, plus the current hash.
+	mux.HandleFunc("/api/photos/watch", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			w.Header().Set("Allow", http.MethodGet)
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
-		photos, err := scanPhotos(absPhotosDir)
+		photos, hash, changed, err := index.snapshot()
 		if err != nil {
 			http.Error(w, "failed to scan photos directory", http.StatusInternalServerError)
-			log.Printf("scan error: %v", err)
 			return
 		}
+		if since := r.URL.Query().Get("hash"); since != "" && since == hash {
+			select {
+			case <-changed:
+				photos, hash, _, _ = index.snapshot()
+			case <-time.After(watchTimeout):
+			case <-r.Context().Done():
+			case <-shuttingDown:
+				// Answer with what we have so shutdown needn't wait.
+			}
+		}
 
-		// Optional ordering controls via query params:
-		// ?order=mtime_desc|mtime_asc|name_asc|name_desc (default mtime_desc)
+		// The index's slice is shared; sort a copy.
+		photos = append([]Photo(nil), photos...)
+		markFavorites(favorites, photos)
 		order := r.URL.Query().Get("order")
-		sortPhotos(photos, order)
+		if album := r.URL.Query().Get("album"); album != "" {
+			var ok bool
+			photos, ok = storeAlbumPhotos(store, album, photos)
+			if !ok {
+				http.Error(w, "album not found", http.StatusNotFound)
+				return
+			}
+			if order == "" {
+				order = storeAlbumOrder(store, album)
+			}
+		}
+		if maxPixels, err := strconv.ParseInt(r.URL.Query().Get("maxpixels"), 10, 64); err == nil && maxPixels > 0 {
+			photos = limitPixels(photos, maxPixels)
+		}
+		photos = sortPhotos(photos, order)
+
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, PhotosResponse{Photos: photos, Count: len(photos), Hash: hash})
+	})
+
+	// API: photos taken on today's date in earlier years, newest first, by
+	// EXIF date (or mtime). Takes ?album= and ?fields= like /api/photos.
+	mux.HandleFunc("/api/photos/onthisday", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		q := r.URL.Query()
+		fields, err := parseFields(q.Get("fields"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		photos, hash, _, err := index.snapshot()
+		if err != nil {
+			http.Error(w, "failed to scan photos directory", http.StatusInternalServerError)
+			return
+		}
+		// The index's slice is shared; filter a copy.
+		photos = append([]Photo(nil), photos...)
+		if album := q.Get("album"); album != "" {
+			var ok bool
+			photos, ok = storeAlbumPhotos(store, album, photos)
+			if !ok {
+				http.Error(w, "album not found", http.StatusNotFound)
+				return
+			}
+		}
+		photos = onThisDay(photos, time.Now())
+		markFavorites(favorites, photos)
+
+		etag := photosETag(q.Encode(), photos)
+		w.Header().Set("ETag", etag)
+		w.Header().Set("X-Photos-Hash", hash)
+		w.Header().Set("Cache-Control", "no-cache")
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if photos == nil {
+			photos = []Photo{}
+		}
+		writeJSON(w, projectResponse(PhotosResponse{Photos: photos, Count: len(photos)}, fields))
+	})
+
+	// API: metadata for many photos at once, POST /api/photos/meta
+	if isLocal {
+		mux.HandleFunc("/api/photos/meta", metaBatchHandler(absPhotosDir, recursive))
+	}
+
+	// API: per-photo actions, /api/photos/<name>/<action>
+	//   GET meta                 dimensions, EXIF
+	//   GET share?ttl=2h         signed link (only with auth on)
+	//   POST/DELETE favorite     mark / unmark (only with FAVORITES_FILE)
+	//   POST rotate?deg=90       turn the file clockwise (only with ALLOW_EDIT)
+	// photoActionMethods is what /api/photos/<name>/<action> accepts, or ""
+	// if there's no such action (or it's turned off).
+	photoActionMethods := func(action string) string {
+		switch {
+		case (action == "meta" && isLocal) || (action == "share" && len(shareKey) > 0):
+			return http.MethodGet
+		case action == "favorite" && favorites != nil:
+			return "POST, DELETE"
+		case action == "rotate" && allowEdit:
+			return http.MethodPost
+		}
+		return ""
+	}
+	mux.HandleFunc("/api/photos/", func(w http.ResponseWriter, r *http.Request) {
+		rest := strings.TrimPrefix(r.URL.Path, "/api/photos/")
+		i := strings.LastIndex(rest, "/")
+		if i < 0 {
+			http.NotFound(w, r)
+			return
+		}
+		name, action := rest[:i], rest[i+1:]
+
+		allow := photoActionMethods(action)
+		if allow == "" {
+			http.NotFound(w, r)
+			return
+		}
+		if !slices.Contains(strings.Split(allow, ", "), r.Method) {
+			w.Header().Set("Allow", allow)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if action == "favorite" && r.Method == http.MethodDelete {
+			// The photo may be gone already; the name just has to be sane.
+			if _, err := safeJoin(absPhotosDir, name); err != nil {
+				http.NotFound(w, r)
+				return
+			}
+			if err := favorites.set(name, false); err != nil {
+				logRequestf(r, "favorites: %v", err)
+				http.Error(w, "failed to save favorites", http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		fi, ok := statStored(w, r, store, name)
+		if !ok {
+			return
+		}
+		// Only meaningful for the local-only actions (meta, rotate).
+		fullPath := filepath.Join(absPhotosDir, filepath.FromSlash(name))
+
+		switch action {
+		case "favorite":
+			if err := favorites.set(name, true); err != nil {
+				logRequestf(r, "favorites: %v", err)
+				http.Error(w, "failed to save favorites", http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		case "rotate":
+			turn, ok := rotateOrientations[r.URL.Query().Get("deg")]
+			if !ok {
+				http.Error(w, "deg must be 90, 180 or 270", http.StatusBadRequest)
+				return
+			}
+			orientation := photoMetas.get(fullPath, fi.ModTime().Unix()).Orientation
+			width, height, err := rotatePhoto(fullPath, fi, orientation, turn)
+			switch {
+			case errors.Is(err, errRotateUnsupported):
+				http.Error(w, errRotateUnsupported.Error(), http.StatusUnsupportedMediaType)
+				return
+			case err != nil:
+				logRequestf(r, "rotate error: %s: %v", name, err)
+				http.Error(w, "failed to rotate photo", http.StatusInternalServerError)
+				return
+			}
+			logRequestf(r, "Rotated %s by %s°", name, r.URL.Query().Get("deg"))
+
+			resp := RotateResponse{Name: name, Width: width, Height: height}
+			if p, ok := statPhoto(absPhotosDir, name, nil); ok {
+				resp.URL = p.URL
+			}
+			w.Header().Set("Cache-Control", "no-store")
+			writeJSON(w, resp)
+
+		case "share":
+			ttl, err := parseShareTTL(r.URL.Query().Get("ttl"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			exp := time.Now().Add(ttl).Unix()
+			w.Header().Set("Cache-Control", "no-store")
+			writeJSON(w, ShareResponse{URL: shareURL(shareKey, name, exp), Expires: exp})
+
+		default:
+			meta := photoMetas.get(fullPath, fi.ModTime().Unix())
+			meta.Name = name
 
-		resp := PhotosResponse{Photos: photos, Count: len(photos)}
+			w.Header().Set("Cache-Control", "no-cache")
+			writeJSON(w, meta)
+		}
+	})
+
+	// API: slideshow defaults for the frontend
+	mux.HandleFunc("/api/config", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		cfg := slideConfig
+		cfg.DefaultOrder = defaultOrder
+		// Optional: ?preset=<token> gives the config that preset makes.
+		if token := r.URL.Query().Get("preset"); token != "" {
+			params, err := decodePreset(presetKey, token)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			cfg = applyPreset(cfg, params)
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+		writeJSON(w, cfg)
+	})
 
-		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	// API: slideshow presets. GET /api/preset?order=name_asc&seconds=30
+	// validates the params and signs them into one token for
+	// /?preset=<token>; GET /api/preset?preset=<token> reads one back.
+	mux.HandleFunc("/api/preset", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		q := r.URL.Query()
+		var params url.Values
+		var err error
+		if q.Has("preset") {
+			params, err = decodePreset(presetKey, q.Get("preset"))
+		} else {
+			params, err = normalizePreset(q)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		token := encodePreset(presetKey, params)
+		resp := PresetResponse{Preset: token, URL: "/?preset=" + token, Params: make(map[string]string, len(params))}
+		for k := range params {
+			resp.Params[k] = params.Get(k)
+		}
 		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, resp)
+	})
 
-		enc := json.NewEncoder(w)
-		enc.SetIndent("", "  ")
-		_ = enc.Encode(resp)
+	// API: machine-readable description of the API (static/openapi.json),
+	// for generating clients. Keep it in step with the handlers.
+	mux.HandleFunc("/api/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		serveEmbeddedFile(w, r, "static/openapi.json", "application/json")
 	})
 
+	// API: when the listing was last scanned and how long that took.
+	mux.HandleFunc("/api/stats", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, index.stats())
+	})
+
+	// API: Server-Sent Events, "photos-changed" (with the new hash) whenever
+	// the listing changes. An EventSource alternative to /api/photos/watch.
+	mux.HandleFunc("/api/events", eventsHandler(index))
+
+	// API: synced slideshow (only with SLIDESHOW_INTERVAL)
+	//   GET /api/slideshow/state    current photo and when it changes next
+	//   GET /api/slideshow/events   the same as Server-Sent Events, on every change
+	if slideshowInterval > 0 {
+		show := newSlideshow(index, slideshowInterval)
+		go show.run()
+		if castWebhookURL != "" {
+			go newCastWebhook(castWebhookURL, castBaseURL, shareKey).run(show)
+		}
+		mux.HandleFunc("/api/slideshow/state", show.serveState)
+		mux.HandleFunc("/api/slideshow/events", show.serveEvents)
+	}
+
+	// API: add photos, POST /api/upload (multipart/form-data)
+	if allowUpload {
+		mux.HandleFunc("/api/upload", uploadHandler(absPhotosDir, maxUploadBytes))
+	}
+
 	// Serve individual photos safely
+	images := &imageCache{
+		cache:        derived,
+		autoOrient:   autoOrient,
+		timeout:      imageTimeout,
+		fallback:     fallback,
+		queueTimeout: transcodeQueueTimeout,
+	}
+	if transcodeConcurrency > 0 {
+		images.slots = make(chan struct{}, transcodeConcurrency)
+	}
 	mux.HandleFunc("/photos/", func(w http.ResponseWriter, r *http.Request) {
+		allowed := r.Method == http.MethodGet || r.Method == http.MethodHead ||
+			(allowDelete && r.Method == http.MethodDelete)
+		if !allowed {
+			if allowDelete {
+				w.Header().Set("Allow", "GET, HEAD, DELETE")
+			} else {
+				w.Header().Set("Allow", "GET, HEAD")
+			}
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		name := strings.TrimPrefix(r.URL.Path, "/photos/")
+		if !isLocal {
+			w.Header().Set("Cache-Control", photoCacheControl)
+			images.serveStored(w, r, store, name, wantsDownload(r))
+			return
+		}
+		fullPath, fi, ok := lookupPhoto(absPhotosDir, recursive, name)
+		if !ok && caseInsensitive {
+			// Optional: CASE_INSENSITIVE=true retries with the name as
+			// it's actually spelled on disk.
+			if actual, found := foldPhotoName(absPhotosDir, recursive, name); found {
+				if fullPath, fi, ok = lookupPhoto(absPhotosDir, recursive, actual); ok {
+					name = actual
+				}
+			}
+		}
+		if !ok {
+			_, valid := photoPath(absPhotosDir, recursive, name)
+			images.missingPhoto(w, r, valid && r.Method != http.MethodDelete)
+			return
+		}
+
+		if r.Method != http.MethodDelete && stillWriting(fullPath, fi) {
+			serveStillWriting(w, fi)
+			return
+		}
+
+		if r.Method == http.MethodDelete {
+			if err := os.Remove(fullPath); err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					http.NotFound(w, r)
+					return
+				}
+				logRequestf(r, "delete error: %s: %v", name, err)
+				http.Error(w, "failed to delete photo", http.StatusInternalServerError)
+				return
+			}
+			logRequestf(r, "Deleted %s", name)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		// A stale ?v= (the cache-buster from the listing) would cache the
+		// new contents under the old key; send the client to the current one.
+		if target, stale := versionRedirect(r.URL, fi.ModTime().Unix()); stale {
+			w.Header().Set("Cache-Control", "no-store")
+			http.Redirect(w, r, target, http.StatusFound)
+			return
+		}
+
+		// Cache images aggressively; list refresh handles new images.
+		w.Header().Set("Cache-Control", photoCacheControl)
+
+		// Optional: ?download=1 saves the untouched file instead of
+		// displaying it.
+		if wantsDownload(r) {
+			images.serveDownload(w, r, name, fullPath, fi)
+			return
+		}
+		images.serveOriginal(w, r, name, fullPath, fi)
+	})
+
+	// Downscaled JPEGs: /thumb/<name>?w=<max width>
+	mux.HandleFunc("/thumb/", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet && r.Method != http.MethodHead {
 			w.Header().Set("Allow", "GET, HEAD")
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
-		name := strings.TrimPrefix(r.URL.Path, "/photos/")
-		if name == "" {
-			http.NotFound(w, r)
+		name := strings.TrimPrefix(r.URL.Path, "/thumb/")
+		width, err := strconv.Atoi(r.URL.Query().Get("w"))
+		if err != nil || width < 1 {
+			http.Error(w, "w must be a positive integer", http.StatusBadRequest)
 			return
 		}
+		if width > maxThumbWidth {
+			width = maxThumbWidth
+		}
 
-		// Only allow file names (no subdirectories) to keep it simple + safe.
-		if strings.Contains(name, "/") || strings.Contains(name, `\`) {
-			http.NotFound(w, r)
+		if !isLocal {
+			w.Header().Set("Cache-Control", photoCacheControl)
+			images.serveStoredThumb(w, r, store, name, width)
+			return
+		}
+		fullPath, fi, ok := lookupPhoto(absPhotosDir, recursive, name)
+		if !ok {
+			_, valid := photoPath(absPhotosDir, recursive, name)
+			images.missingPhoto(w, r, valid)
+			return
+		}
+		if stillWriting(fullPath, fi) {
+			serveStillWriting(w, fi)
 			return
 		}
 
-		// Extension allowlist
-		if !isAllowedExt(name) {
-			http.NotFound(w, r)
+		w.Header().Set("Cache-Control", photoCacheControl)
+		images.serveThumb(w, r, name, fullPath, fi, width)
+	})
+
+	// Health check (left intentionally unauthenticated so health checks work cleanly)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok"))
+	})
+
+	// Build info for fleet inventories; unauthenticated like /healthz.
+	mux.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, buildInfo())
+	})
+
+	// Readiness: can we actually serve photos? 503 with a reason if not.
+	// Also unauthenticated, like /healthz.
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+
+		ready := ReadyResponse{Status: "ok"}
+		if !isLocal {
+			// Remote stores are only reached through the index's scans.
+			photos, _, _, err := index.snapshot()
+			if err != nil {
+				ready.Reason = "photo storage: " + err.Error()
+			} else if len(photos) == 0 {
+				ready.Reason = "no photos found"
+			} else {
+				ready.Photos = len(photos)
+			}
+		} else if fi, err := os.Stat(absPhotosDir); err != nil {
+			ready.Reason = "photos directory: " + err.Error()
+		} else if !fi.IsDir() {
+			ready.Reason = "photos directory: not a directory"
+		} else if _, err := os.ReadDir(absPhotosDir); err != nil {
+			ready.Reason = "photos directory: " + err.Error()
+		} else if photos, _, _, _ := index.snapshot(); len(photos) == 0 {
+			ready.Reason = "no photos found"
+		} else {
+			ready.Photos = len(photos)
+		}
+
+		if ready.Reason != "" {
+			ready.Status = "unavailable"
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		writeJSON(w, ready)
+	})
+
+	// Prometheus metrics
+	registerMetrics()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	var handler http.Handler = mux
+	handler = securityHeaders(csp, frameOptions, handler)
+	handler = gzipMiddleware(handler)
+
+	// Wrap with auth if AUTH_TOKEN / AUTH_TOKENS is configured
+	if len(authTokens) > 0 {
+		// /healthz, /readyz and /version stay open for infra checks; /metrics
+		// too unless METRICS_AUTH=true.
+		exempt := []string{"/healthz", "/readyz", "/version"}
+		if !metricsAuth {
+			exempt = append(exempt, "/metrics")
+		}
+		exempt = append(exempt, authExempt...)
+		handler = authMiddleware(authConfig{
+			tokens:         authTokens,
+			exempt:         exempt,
+			basicUser:      basicAuthUser,
+			shareKey:       shareKey,
+			trusted:        trustedNets,
+			cookieName:     cookieName,
+			cookieMaxAge:   cookieMaxAge,
+			cookieDomain:   cookieDomain,
+			cookieSameSite: cookieSameSite,
+		}, handler)
+	}
+
+	// OPTIONS gets each route's Allow header, and CORS preflights their
+	// Access-Control-Allow-Methods, without needing a token. The methods
+	// here have to match the handlers' own checks; routes left out are
+	// GET/HEAD pages.
+	routeMethods := map[string]string{
+		"/info":                 http.MethodGet,
+		"/api/photos/watch":     http.MethodGet,
+		"/api/photos/meta":      http.MethodPost,
+		"/api/config":           http.MethodGet,
+		"/api/preset":           http.MethodGet,
+		"/api/stats":            http.MethodGet,
+		"/api/events":           http.MethodGet,
+		"/api/slideshow/state":  http.MethodGet,
+		"/api/slideshow/events": http.MethodGet,
+		"/api/upload":           http.MethodPost,
+	}
+	if allowDelete {
+		routeMethods["/photos/"] = "GET, HEAD, DELETE"
+	}
+	routeAllows := func(pattern, path string) string {
+		switch pattern {
+		case "/":
+			if path != "/" {
+				return ""
+			}
+		case "/api/photos/":
+			rest := strings.TrimPrefix(path, "/api/photos/")
+			i := strings.LastIndex(rest, "/")
+			if i < 0 {
+				return ""
+			}
+			return photoActionMethods(rest[i+1:])
+		}
+		if m, ok := routeMethods[pattern]; ok {
+			return m
+		}
+		return "GET, HEAD"
+	}
+	handler = optionsMiddleware(mux, routeAllows, handler)
+
+	// Preflights carry no credentials, so CORS has to run before auth.
+	if len(corsOrigins) > 0 {
+		handler = corsMiddleware(corsOrigins, mux, routeAllows, handler)
+	}
 
-		fullPath, err := safeJoin(absPhotosDir, name)
+	// Runs before auth so credentials are only ever exchanged over https.
+	if redirectHTTPS {
+		handler = httpsRedirect(handler)
+	}
+
+	handler = metricsMiddleware(mux, handler)
+	handler = loggingMiddleware(logFormat, basicAuthUser, handler)
+	handler = requestIDMiddleware(handler)
+
+	// Stop cleanly on Ctrl-C / `docker stop` so in-flight downloads can finish.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	addr := net.JoinHostPort(bindAddr, port)
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		log.Fatalf("invalid PORT=%q", port)
+	}
+	if bindAddr != "" && net.ParseIP(bindAddr) == nil {
+		if _, err := net.LookupHost(bindAddr); err != nil {
+			log.Fatalf("invalid BIND_ADDR=%q: %v", bindAddr, err)
+		}
+	}
+	// Bind up front so a bad address or a port in use stops startup with a
+	// clear message instead of failing in the background.
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("cannot listen on %s: %v", addr, err)
+	}
+
+	var tlsConfig *tls.Config
+	if tlsCert != "" || tlsKey != "" {
+		if tlsCert == "" || tlsKey == "" {
+			log.Fatalf("TLS_CERT and TLS_KEY must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(tlsCert, tlsKey)
 		if err != nil {
-			http.NotFound(w, r)
-			return
+			log.Fatalf("failed to load TLS_CERT/TLS_KEY: %v", err)
+		}
+		// NextProtos is left empty so ServeTLS adds "h2" and clients get
+		// HTTP/2 without further setup.
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+	if h2cEnabled {
+		if tlsConfig != nil {
+			log.Printf("H2C ignored: TLS is on and already serves HTTP/2")
+		} else {
+			handler = h2c.NewHandler(handler, &http2.Server{IdleTimeout: idleTimeout})
+		}
+	}
+
+	srv := &http.Server{
+		Addr:              addr,
+		TLSConfig:         tlsConfig,
+		Handler:           handler,
+		ReadTimeout:       readTimeout,
+		ReadHeaderTimeout: readHeaderTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+	srv.RegisterOnShutdown(func() { close(shuttingDown) })
+
+	log.Printf("Timeouts: read=%s read_header=%s write=%s idle=%s", readTimeout, readHeaderTimeout, writeTimeout, idleTimeout)
+	go func() {
+		var err error
+		if tlsConfig != nil {
+			log.Printf("Listening on %s (TLS)", addr)
+			err = srv.ServeTLS(ln, "", "")
+		} else {
+			if h2cEnabled {
+				log.Printf("Listening on %s (h2c)", addr)
+			} else {
+				log.Printf("Listening on %s", addr)
+			}
+			err = srv.Serve(ln)
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	log.Printf("Shutting down (waiting up to %s for in-flight requests)", shutdownTimeout)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("shutdown: %v", err)
+	}
+	log.Printf("Shutdown complete")
+}
+
+// shuttingDown is closed once shutdown starts. Long-polls and event streams
+// end on it rather than holding Shutdown for their full timeout; other
+// requests keep their contexts and finish normally.
+var shuttingDown = make(chan struct{})
+
+func getenv(k, def string) string {
+	v := strings.TrimSpace(os.Getenv(k))
+	if v == "" {
+		return def
+	}
+	return v
+}
+
+// prettyJSON (PRETTY_JSON) indents API responses for people reading them;
+// compact output is much smaller for big listings.
+var prettyJSON = true
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	enc := json.NewEncoder(w)
+	if prettyJSON {
+		enc.SetIndent("", "  ")
+	}
+	_ = enc.Encode(v)
+}
+
+// customStaticDir (CUSTOM_STATIC_DIR) overrides embedded static files with
+// same-named files from disk; empty means embedded only.
+var customStaticDir string
+
+// serveEmbeddedFile serves path ("static/…") from customStaticDir when the
+// file exists there, otherwise from the embedded FS.
+func serveEmbeddedFile(w http.ResponseWriter, r *http.Request, path string, forcedContentType string) {
+	b, err := readCustomStatic(path)
+	if err != nil {
+		b, err = staticFS.ReadFile(path)
+	}
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if forcedContentType != "" {
+		w.Header().Set("Content-Type", forcedContentType)
+	} else {
+		ext := strings.ToLower(filepath.Ext(path))
+		if ct := mime.TypeByExtension(ext); ct != "" {
+			w.Header().Set("Content-Type", ct)
+		} else {
+			w.Header().Set("Content-Type", "application/octet-stream")
+		}
+	}
+
+	// Static assets can be cached
+	// (The service worker must not be: browsers check it for updates.)
+	if strings.HasPrefix(path, "static/") && path != "static/index.html" && path != "static/info.html" && path != "static/sw.js" {
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+	} else {
+		w.Header().Set("Cache-Control", "no-store")
+	}
+
+	_, _ = w.Write(b)
+}
+
+// readCustomStatic reads path ("static/…") from customStaticDir. Hidden
+// files and anything resolving outside the directory are never served.
+func readCustomStatic(path string) ([]byte, error) {
+	if customStaticDir == "" {
+		return nil, fs.ErrNotExist
+	}
+	name := strings.TrimPrefix(path, "static/")
+	for _, elem := range strings.Split(name, "/") {
+		if strings.HasPrefix(elem, ".") {
+			return nil, fs.ErrNotExist
+		}
+	}
+	// SYMLINK_POLICY is about photos; the overlay keeps the strict rules.
+	fullPath, err := safeJoinWith(customStaticDir, name, symlinkReject)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, fs.ErrNotExist
+	}
+	return os.ReadFile(fullPath)
+}
+
+func getenvBool(k string, def bool) bool {
+	v := strings.ToLower(strings.TrimSpace(os.Getenv(k)))
+	switch v {
+	case "":
+		return def
+	case "1", "true", "yes", "on":
+		return true
+	default:
+		return false
+	}
+}
+
+// getenvInt reads a non-negative integer.
+func getenvInt(k string, def int) int {
+	v := strings.TrimSpace(os.Getenv(k))
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		log.Printf("invalid %s=%q, using %d", k, v, def)
+		return def
+	}
+	return n
+}
+
+// getenvDuration reads a Go duration such as "10s" or "1m30s".
+func getenvDuration(k string, def time.Duration) time.Duration {
+	v := strings.TrimSpace(os.Getenv(k))
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		log.Printf("invalid %s=%q, using %s", k, v, def)
+		return def
+	}
+	return d
+}
+
+// scanPhotos lists every photo in store, in name order. Files that can't be
+// read are left out and collected in the returned scanErrors rather than
+// failing the scan.
+func scanPhotos(store photoStore) ([]Photo, *scanErrors, error) {
+	var photos []Photo
+	errs := &scanErrors{}
+	err := store.List(func(p Photo) error {
+		photos = append(photos, p)
+		return nil
+	}, errs)
+	if err != nil {
+		return nil, errs, err
+	}
+	// List's order depends on which stat finishes first; stableHash needs
+	// the same order for the same files.
+	slices.SortFunc(photos, compareNames)
+	applyWeights(storeWeights(store), photos)
+	return photos, errs, nil
+}
+
+// scanWorkers (SCAN_WORKERS) is how many files walkPhotos stats at once.
+// Each stat is a round trip on a network mount, so overlapping them speeds
+// up scans there a lot; on local disks it hardly matters.
+var scanWorkers = 8
+
+// walkPhotos calls fn for every servable photo in dir, in no particular
+// order, without holding the whole listing in memory. Files are statted by
+// scanWorkers goroutines, but fn is only ever called from one at a time.
+// Weights and favorites are left for the caller. An error from fn stops the
+// walk and is returned; files and folders that can't be read go to errs.
+func walkPhotos(dir string, recursive bool, fn func(Photo) error, errs *scanErrors) error {
+	names := make(chan string)
+	found := make(chan Photo)
+	stop := make(chan struct{}) // closed when fn fails
+	var workers sync.WaitGroup
+	for range max(scanWorkers, 1) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for name := range names {
+				p, ok := statPhoto(dir, name, errs)
+				if !ok {
+					continue
+				}
+				select {
+				case found <- p:
+				case <-stop:
+					return
+				}
+			}
+		}()
+	}
+
+	walkErr := make(chan error, 1)
+	go func() {
+		walkErr <- walkPhotoNames(dir, recursive, func(name string) error {
+			select {
+			case names <- name:
+				return nil
+			case <-stop:
+				return errWalkStopped
+			}
+		}, errs)
+		close(names)
+		workers.Wait()
+		close(found)
+	}()
+
+	var err error
+	for p := range found {
+		if err != nil {
+			continue
+		}
+		if err = fn(p); err != nil {
+			close(stop)
 		}
+	}
+	werr := <-walkErr
+	if err != nil {
+		return err
+	}
+	return werr
+}
 
-		fi, err := os.Stat(fullPath)
-		if err != nil || fi.IsDir() {
-			http.NotFound(w, r)
-			return
+var errWalkStopped = errors.New("walk stopped")
+
+// walkPhotoNames calls fn with the name of every file in dir (and, with
+// recursive, its subfolders) that might be a photo, skipping ignored
+// folders and anything .frameserveignore leaves out. statPhoto has the
+// final say. Symlinks are handled per symlinkPolicy: with "skip" they're
+// left out here, and with "follow" linked folders are walked too.
+// Subfolders that can't be read are skipped and reported to errs.
+func walkPhotoNames(dir string, recursive bool, fn func(string) error, errs *scanErrors) error {
+	rules := photoIgnores.load(dir)
+	if !recursive {
+		f, err := os.Open(dir)
+		if err != nil {
+			return err
 		}
-
-		// Content-Type best effort based on extension
-		ct := mime.TypeByExtension(strings.ToLower(filepath.Ext(name)))
-		if ct != "" {
-			w.Header().Set("Content-Type", ct)
+		defer f.Close()
+
+		for {
+			entries, err := f.ReadDir(256)
+			for _, e := range entries {
+				if e.IsDir() || rules.match([]string{e.Name()}, false) {
+					continue
+				}
+				if symlinkPolicy == symlinkSkip && e.Type()&fs.ModeSymlink != 0 {
+					continue
+				}
+				if err := fn(e.Name()); err != nil {
+					return err
+				}
+			}
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
 		}
+	}
 
-		// Cache images aggressively; list refresh handles new images.
-		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	// Linked folders already walked, so links in a loop end.
+	seen := make(map[string]bool)
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		seen[resolved] = true
+	}
 
-		http.ServeFile(w, r, fullPath)
-	})
+	// walk visits root, whose files are named prefix + their path below it.
+	var walk func(root, prefix string) error
+	walk = func(root, prefix string) error {
+		return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if p == dir {
+					return err
+				}
+				// Unreadable subdirectory: skip it rather than failing the whole scan.
+				if rel, rerr := filepath.Rel(root, p); rerr == nil {
+					errs.add(path.Join(prefix, filepath.ToSlash(rel)), err)
+				}
+				if d != nil && d.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if p == root {
+				return nil
+			}
+			rel, err := filepath.Rel(root, p)
+			if err != nil {
+				return nil
+			}
+			name := prefix + filepath.ToSlash(rel)
+			elems := strings.Split(name, "/")
+
+			if d.Type()&fs.ModeSymlink != 0 {
+				switch symlinkPolicy {
+				case symlinkSkip:
+					return nil
+				case symlinkFollow:
+					if fi, err := os.Stat(p); err == nil && fi.IsDir() {
+						resolved, err := filepath.EvalSymlinks(p)
+						if err != nil || seen[resolved] || isIgnoredName(d.Name()) || rules.match(elems, true) {
+							return nil
+						}
+						seen[resolved] = true
+						return walk(resolved, name+"/")
+					}
+				}
+			}
+			if d.IsDir() {
+				if isIgnoredName(d.Name()) || rules.match(elems, true) {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if rules.match(elems, false) {
+				return nil
+			}
+			return fn(name)
+		})
+	}
+	return walk(dir, "")
+}
 
-	// Health check (left intentionally unauthenticated so health checks work cleanly)
-	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte("ok"))
-	})
+// lookupPhoto resolves a requested photo name (as used in /photos/ URLs) to a
+// regular file inside baseDir, applying the same rules as scanPhotos.
+func lookupPhoto(baseDir string, recursive bool, name string) (string, os.FileInfo, bool) {
+	fullPath, ok := photoPath(baseDir, recursive, name)
+	if !ok {
+		return "", nil, false
+	}
 
-	var handler http.Handler = mux
-	handler = securityHeaders(handler)
+	fi, err := os.Stat(fullPath)
+	if err != nil || fi.IsDir() || tooBig(name, fi.Size()) {
+		return "", nil, false
+	}
+	return fullPath, fi, true
+}
 
-	// Wrap with auth if AUTH_TOKEN is configured
-	if authToken != "" {
-		handler = authMiddleware(authToken, handler)
+// photoPath is the file a photo name maps to inside baseDir, or false if the
+// name could never be a photo (bad path, extension or ignored), whether or
+// not the file exists.
+func photoPath(baseDir string, recursive bool, name string) (string, bool) {
+	if name == "" {
+		return "", false
 	}
 
-	srv := &http.Server{
-		Addr:              ":" + port,
-		Handler:           handler,
-		ReadHeaderTimeout: 5 * time.Second,
+	// Nested paths are only valid when subdirectories are scanned.
+	if strings.Contains(name, `\`) || (!recursive && strings.Contains(name, "/")) {
+		return "", false
 	}
 
-	log.Printf("Listening on :%s", port)
-	log.Fatal(srv.ListenAndServe())
-}
+	// Extension allowlist (checked on the basename)
+	if !isAllowedExt(path.Base(name)) || isIgnored(name) || photoIgnores.load(baseDir).ignored(name) {
+		return "", false
+	}
 
-func getenv(k, def string) string {
-	v := strings.TrimSpace(os.Getenv(k))
-	if v == "" {
-		return def
+	fullPath, err := safeJoin(baseDir, name)
+	if err != nil {
+		return "", false
 	}
-	return v
+	return fullPath, true
 }
 
-func serveEmbeddedFile(w http.ResponseWriter, r *http.Request, path string, forcedContentType string) {
-	b, err := staticFS.ReadFile(path)
+// albumPhotos narrows photos to those inside album, a slash-separated
+// subdirectory of baseDir (nested albums like "2024/summer" work too). It
+// reports false if album isn't a directory inside baseDir; albums are only
+// available with RECURSIVE=true, since otherwise subdirectories aren't served.
+// photos is filtered in place.
+func albumPhotos(baseDir string, recursive bool, album string, photos []Photo) ([]Photo, bool) {
+	if !recursive {
+		return nil, false
+	}
+	dir, err := safeJoin(baseDir, album)
 	if err != nil {
-		http.NotFound(w, r)
-		return
+		return nil, false
+	}
+	fi, err := os.Stat(dir)
+	if err != nil || !fi.IsDir() {
+		return nil, false
 	}
 
-	if forcedContentType != "" {
-		w.Header().Set("Content-Type", forcedContentType)
-	} else {
-		ext := strings.ToLower(filepath.Ext(path))
-		if ct := mime.TypeByExtension(ext); ct != "" {
-			w.Header().Set("Content-Type", ct)
-		} else {
-			w.Header().Set("Content-Type", "application/octet-stream")
-		}
+	rel, err := filepath.Rel(baseDir, dir)
+	if err != nil || rel == "." {
+		return photos, true
 	}
+	prefix := filepath.ToSlash(rel) + "/"
 
-	// Static assets can be cached
-	if strings.HasPrefix(path, "static/") && path != "static/index.html" && path != "static/info.html" {
-		w.Header().Set("Cache-Control", "public, max-age=86400")
-	} else {
-		w.Header().Set("Cache-Control", "no-store")
+	filtered := photos[:0]
+	for _, p := range photos {
+		if strings.HasPrefix(p.Name, prefix) {
+			filtered = append(filtered, p)
+		}
 	}
+	return filtered, true
+}
 
-	_, _ = w.Write(b)
+// albumOrderFile, inside an album folder, holds the order ("name_asc", …)
+// that album is shown in when the client doesn't ask for one.
+const albumOrderFile = ".order"
+
+// albumOrder reads album's albumOrderFile, returning "" if there is none
+// or it doesn't name a known order.
+func albumOrder(baseDir, album string) string {
+	dir, err := safeJoin(baseDir, album)
+	if err != nil {
+		return ""
+	}
+	b, err := os.ReadFile(filepath.Join(dir, albumOrderFile))
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("album order: %v", err)
+		}
+		return ""
+	}
+	first, _, _ := strings.Cut(string(b), "\n")
+	order := strings.ToLower(strings.TrimSpace(first))
+	if !slices.Contains(sortOrders, order) {
+		log.Printf("album order: %s/%s: unknown order %q", album, albumOrderFile, order)
+		return ""
+	}
+	return order
 }
 
-func scanPhotos(dir string) ([]Photo, error) {
-	entries, err := os.ReadDir(dir)
+// statPhoto builds the Photo entry for name (a slash-separated path relative
+// to dir), reporting false if it isn't a servable image. Files that exist
+// but can't be statted or opened are reported to errs.
+func statPhoto(dir, name string, errs *scanErrors) (Photo, bool) {
+	// Formats this build can't transcode would only show up as broken images.
+	if !isAllowedExt(path.Base(name)) || !canDisplay(name) || isIgnored(name) {
+		return Photo{}, false
+	}
+
+	fullPath, err := safeJoin(dir, name)
 	if err != nil {
-		return nil, err
+		return Photo{}, false
 	}
 
-	var photos []Photo
-	for _, e := range entries {
-		if e.IsDir() {
-			continue
-		}
-		name := e.Name()
-		if !isAllowedExt(name) {
-			continue
+	// Too new files may still be being copied in; a later rescan adds them.
+	fi, err := os.Stat(fullPath)
+	if err != nil {
+		// Gone since the directory was read: nothing to report.
+		if !errors.Is(err, fs.ErrNotExist) {
+			errs.add(name, err)
 		}
+		return Photo{}, false
+	}
+	if fi.IsDir() || tooNew(fi) || tooBig(name, fi.Size()) {
+		return Photo{}, false
+	}
+
+	mtime := fi.ModTime().Unix()
+	// Cache-bust param v=mtime so browsers refresh when a file changes.
+	url := fmt.Sprintf("/photos/%s?v=%d", urlPathEscape(name), mtime)
 
-		fullPath, err := safeJoin(dir, name)
+	// Header-only read, cached per path+mtime, so rescans stay cheap. Videos
+	// have no image header to read, but have to be readable all the same.
+	var meta PhotoMeta
+	if isVideo(name) {
+		f, err := os.Open(fullPath)
 		if err != nil {
-			continue
+			errs.add(name, err)
+			return Photo{}, false
 		}
+		f.Close()
+	} else if meta, err = photoMetas.load(fullPath, mtime); err != nil {
+		errs.add(name, err)
+		return Photo{}, false
+	}
 
-		fi, err := os.Stat(fullPath)
-		if err != nil || fi.IsDir() {
-			continue
-		}
+	var blur string
+	if blurhashes != nil && !isVideo(name) {
+		blur = blurhashes.get(fullPath, mtime, meta.Orientation)
+	}
 
-		mtime := fi.ModTime().Unix()
-		// Cache-bust param v=mtime so browsers refresh when a file changes.
-		url := fmt.Sprintf("/photos/%s?v=%d", urlPathEscape(name), mtime)
+	return Photo{
+		URL:         url,
+		Name:        name,
+		Mtime:       mtime,
+		Size:        fi.Size(),
+		Width:       meta.Width,
+		Height:      meta.Height,
+		AspectRatio: meta.aspectRatio(),
+		ExifTime:    meta.ExifTime,
+		Blurhash:    blur,
+		Caption:     photoCaptions.get(fullPath),
+		Kind:        photoKind(name),
+	}, true
+}
 
-		photos = append(photos, Photo{
-			URL:   url,
-			Name:  name,
-			Mtime: mtime,
-			Size:  fi.Size(),
-		})
+// limitPixels drops photos with more than maxPixels pixels. Photos whose
+// dimensions are unknown are kept. photos is filtered in place.
+func limitPixels(photos []Photo, maxPixels int64) []Photo {
+	kept := photos[:0]
+	for _, p := range photos {
+		if p.Width == 0 || p.Height == 0 || int64(p.Width)*int64(p.Height) <= maxPixels {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
+
+// versionRedirect reports whether u has a ?v= that isn't mtime, returning
+// the same URL with v corrected. URLs without v are left alone.
+func versionRedirect(u *url.URL, mtime int64) (string, bool) {
+	q := u.Query()
+	want := strconv.FormatInt(mtime, 10)
+	if !q.Has("v") || q.Get("v") == want {
+		return "", false
 	}
+	q.Set("v", want)
+	fixed := *u
+	fixed.RawQuery = q.Encode()
+	return fixed.RequestURI(), true
+}
 
-	return photos, nil
+// parsePage reads the optional ?limit= and ?offset= params. A zero limit
+// means no limit.
+func parsePage(q url.Values) (limit, offset int, err error) {
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 0 {
+			return 0, 0, errors.New("limit must be a non-negative integer")
+		}
+	}
+	if v := q.Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			return 0, 0, errors.New("offset must be a non-negative integer")
+		}
+	}
+	return limit, offset, nil
 }
 
-func sortPhotos(photos []Photo, order string) {
+// sortPhotos orders photos in place and returns them; order=weighted and
+// order=album_balanced return a longer playlist in which photos repeat.
+// Photos that tie (same mtime after a bulk copy, say) are ordered by name,
+// so the listing and its ETag don't change from one request to the next.
+func sortPhotos(photos []Photo, order string) []Photo {
+	if order == "" {
+		order = defaultOrder
+	}
+	mtime := func(p Photo) int64 { return p.Mtime }
+	exifTime := func(p Photo) int64 { return p.effectiveTime() }
 	switch order {
 	case "mtime_asc":
-		sort.Slice(photos, func(i, j int) bool { return photos[i].Mtime < photos[j].Mtime })
+		sortByKey(photos, mtime, false)
 	case "name_asc":
-		sort.Slice(photos, func(i, j int) bool { return strings.ToLower(photos[i].Name) < strings.ToLower(photos[i].Name) })
+		sort.Slice(photos, func(i, j int) bool { return compareNames(photos[i], photos[j]) < 0 })
 	case "name_desc":
-		sort.Slice(photos, func(i, j int) bool { return strings.ToLower(photos[i].Name) > strings.ToLower(photos[j].Name) })
-	case "mtime_desc", "":
+		sort.Slice(photos, func(i, j int) bool { return compareNames(photos[i], photos[j]) > 0 })
+	case "exif_asc":
+		sortByKey(photos, exifTime, false)
+	case "exif_desc":
+		sortByKey(photos, exifTime, true)
+	case "random":
+		// A new order on every call, so ETags never match.
+		rand.Shuffle(len(photos), func(i, j int) { photos[i], photos[j] = photos[j], photos[i] })
+	case "shuffle_daily":
+		// Shuffled, but stable for the whole (server-local) day so polling
+		// clients don't see the order jump around mid-slideshow.
+		sort.Slice(photos, func(i, j int) bool { return compareNames(photos[i], photos[j]) < 0 })
+		rng := rand.New(rand.NewSource(dailySeed(time.Now())))
+		rng.Shuffle(len(photos), func(i, j int) { photos[i], photos[j] = photos[j], photos[i] })
+	case "smart":
+		// Newer photos tend to come first, but every call draws anew, so
+		// (like random) ETags never match.
+		rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+		smartShuffle(photos, time.Now(), rng)
+	case "weighted":
+		// Same as the default order when nothing is weighted.
+		sortByKey(photos, mtime, true)
+		return weightedPlaylist(photos)
+	case "album_balanced":
+		return albumBalanced(photos)
+	case "mtime_desc":
 		fallthrough
 	default:
-		sort.Slice(photos, func(i, j int) bool { return photos[i].Mtime > photos[j].Mtime })
+		sortByKey(photos, mtime, true)
+	}
+	return photos
+}
+
+// sortByKey sorts photos by key, newest/largest first when desc. Equal keys
+// fall back to compareNames (always A to Z).
+func sortByKey(photos []Photo, key func(Photo) int64, desc bool) {
+	sort.Slice(photos, func(i, j int) bool {
+		ki, kj := key(photos[i]), key(photos[j])
+		if ki != kj {
+			return ki < kj != desc
+		}
+		return compareNames(photos[i], photos[j]) < 0
+	})
+}
+
+// compareNames orders photos by name, ignoring case; names that differ
+// only in case are compared exactly, so no two photos ever tie.
+func compareNames(a, b Photo) int {
+	if c := strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name)); c != 0 {
+		return c
+	}
+	return strings.Compare(a.Name, b.Name)
+}
+
+// sortOrders are the ?order= values sortPhotos knows.
+var sortOrders = []string{
+	"mtime_desc", "mtime_asc", "name_asc", "name_desc", "exif_asc", "exif_desc",
+	"random", "shuffle_daily", "weighted", "smart", "album_balanced",
+}
+
+// defaultOrder applies when ?order= is absent; DEFAULT_ORDER replaces it at
+// startup.
+var defaultOrder = "mtime_desc"
+
+// allowedExts is the extension allowlist (lowercase, with leading dot).
+// ALLOWED_EXTENSIONS replaces it at startup.
+var allowedExts = map[string]bool{
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+	".webp": true,
+	".gif":  true,
+	".heic": true,
+	".heif": true,
+	".avif": true,
+}
+
+func dailySeed(t time.Time) int64 {
+	h := fnv.New64a()
+	io.WriteString(h, t.Format("2006-01-02"))
+	return int64(h.Sum64())
+}
+
+// smartHalfLife (SMART_HALFLIFE_DAYS) is how much older a photo has to be
+// to come up half as often with order=smart.
+var smartHalfLife = 30 * 24 * time.Hour
+
+// smartMinWeight keeps even very old photos in the running for order=smart.
+const smartMinWeight = 0.05
+
+// smartShuffle puts photos in a weighted random order in which each photo's
+// weight halves with every smartHalfLife of age, so recent photos tend to
+// come first without old ones being shut out. Each photo is scored
+// log(u)/weight for a uniform u and the scores sorted, which is a weighted
+// draw without replacement (Efraimidis–Spirakis).
+func smartShuffle(photos []Photo, now time.Time, rng *rand.Rand) {
+	type scored struct {
+		photo Photo
+		score float64
+	}
+	s := make([]scored, len(photos))
+	for i, p := range photos {
+		age := max(now.Sub(time.Unix(p.Mtime, 0)), 0)
+		weight := max(math.Exp2(-age.Hours()/smartHalfLife.Hours()), smartMinWeight)
+		// 1-Float64() is in (0, 1], so the log is finite.
+		s[i] = scored{p, math.Log(1-rng.Float64()) / weight}
+	}
+	sort.Slice(s, func(i, j int) bool { return s[i].score > s[j].score })
+	for i := range s {
+		photos[i] = s[i].photo
 	}
 }
 
 func isAllowedExt(name string) bool {
-	ext := strings.ToLower(filepath.Ext(name))
-	switch ext {
-	case ".jpg", ".jpeg", ".png", ".webp", ".gif":
+	if _, ok := videoExts[strings.ToLower(filepath.Ext(name))]; ok {
+		return allowVideo
+	}
+	return allowedExts[strings.ToLower(filepath.Ext(name))]
+}
+
+// ignorePatterns are the IGNORE_PATTERNS globs, matched against each path
+// element of a photo's name.
+var ignorePatterns []string
+
+// isIgnoredName reports whether a single file or folder name is skipped:
+// hidden files (including macOS "._" AppleDouble files) and anything
+// matching IGNORE_PATTERNS.
+func isIgnoredName(name string) bool {
+	if strings.HasPrefix(name, ".") {
 		return true
-	default:
+	}
+	for _, pat := range ignorePatterns {
+		if ok, _ := path.Match(pat, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// isIgnored reports whether name (slash-separated, relative to the photos
+// folder) is a hidden/ignored file or sits inside an ignored folder.
+func isIgnored(name string) bool {
+	for _, elem := range strings.Split(name, "/") {
+		if isIgnoredName(elem) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseIgnorePatterns parses a comma-separated glob list (path.Match syntax,
+// case-sensitive), rejecting malformed patterns.
+func parseIgnorePatterns(s string) ([]string, error) {
+	var patterns []string
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("%q: %w", p, err)
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
+
+// maxPhotoBytes (MAX_PHOTO_BYTES) keeps bigger files out of the listing and
+// /photos/, so a stray huge video or PSD can't hang a frame; 0 = no limit.
+var maxPhotoBytes int64
+
+// tooBig reports whether a file of size bytes is over maxPhotoBytes.
+func tooBig(name string, size int64) bool {
+	if maxPhotoBytes <= 0 || size <= maxPhotoBytes {
+		return false
+	}
+	slog.Debug("skipping oversized photo", "name", name, "size", size, "max", maxPhotoBytes)
+	return true
+}
+
+// photoMinAge (PHOTO_MIN_AGE_SECONDS) hides files modified more recently
+// than this, so photos still being copied in are neither listed nor served.
+var photoMinAge time.Duration
+
+const (
+	// Files modified within settleWindow get a second stat settleDelay later
+	// before being served, in case they are still growing.
+	settleWindow = 2 * time.Second
+	settleDelay  = 50 * time.Millisecond
+)
+
+// tooNew reports whether a file is younger than photoMinAge.
+func tooNew(fi os.FileInfo) bool {
+	return photoMinAge > 0 && time.Since(fi.ModTime()) < photoMinAge
+}
+
+// stillWriting reports whether the file at fullPath looks half-written:
+// younger than photoMinAge, or just modified and changing between two stats.
+func stillWriting(fullPath string, fi os.FileInfo) bool {
+	if tooNew(fi) {
+		return true
+	}
+	if time.Since(fi.ModTime()) > settleWindow {
 		return false
 	}
+	time.Sleep(settleDelay)
+	again, err := os.Stat(fullPath)
+	return err != nil || again.Size() != fi.Size() || !again.ModTime().Equal(fi.ModTime())
+}
+
+// serveStillWriting answers a request for a half-written photo with a 503
+// and a Retry-After for when it should be complete.
+func serveStillWriting(w http.ResponseWriter, fi os.FileInfo) {
+	retry := 1
+	if left := photoMinAge - time.Since(fi.ModTime()); left > 0 {
+		retry = int(math.Ceil(left.Seconds()))
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(retry))
+	w.Header().Set("Cache-Control", "no-store")
+	http.Error(w, "photo is still being written", http.StatusServiceUnavailable)
+}
+
+// parseExtList parses a comma-separated extension list such as
+// ".jpg, PNG,bmp", accepting entries with or without the leading dot.
+func parseExtList(s string) map[string]bool {
+	exts := make(map[string]bool)
+	for _, e := range strings.Split(s, ",") {
+		e = strings.ToLower(strings.TrimSpace(e))
+		e = strings.TrimPrefix(e, ".")
+		if e == "" {
+			continue
+		}
+		exts["."+e] = true
+	}
+	return exts
 }
 
+// Symlink policies (SYMLINK_POLICY) for the photos folder.
+const (
+	// symlinkReject serves symlinks only while they resolve inside the
+	// folder; linked folders aren't scanned.
+	symlinkReject = "reject"
+	// symlinkFollow serves symlinks wherever they point and scans linked
+	// folders, trusting whoever can create links in the folder.
+	symlinkFollow = "follow"
+	// symlinkSkip ignores symlinks altogether.
+	symlinkSkip = "skip"
+)
+
+var symlinkPolicy = symlinkReject
+
+// safeJoin resolves fileName, a slash-separated path relative to baseDir,
+// refusing anything that would land outside baseDir, and treating symlinks
+// as symlinkPolicy says.
 func safeJoin(baseDir, fileName string) (string, error) {
+	return safeJoinWith(baseDir, fileName, symlinkPolicy)
+}
+
+// safeJoinWith is safeJoin with an explicit symlink policy.
+func safeJoinWith(baseDir, fileName, policy string) (string, error) {
 	if fileName == "" {
 		return "", errors.New("empty name")
 	}
-	clean := filepath.Clean(fileName)
-	clean = filepath.Base(clean)
-
-	joined := filepath.Join(baseDir, clean)
+	if strings.Contains(fileName, `\`) {
+		return "", errors.New("invalid separator")
+	}
+	clean := filepath.Clean(filepath.FromSlash(fileName))
+	if filepath.IsAbs(clean) || escapes(clean) {
+		return "", errors.New("path escapes base dir")
+	}
 
 	baseAbs, err := filepath.Abs(baseDir)
 	if err != nil {
 		return "", err
 	}
-	joinedAbs, err := filepath.Abs(joined)
-	if err != nil {
-		return "", err
-	}
+	joinedAbs := filepath.Join(baseAbs, clean)
 
 	rel, err := filepath.Rel(baseAbs, joinedAbs)
 	if err != nil {
 		return "", err
 	}
-	if strings.HasPrefix(rel, ".."+string(filepath.Separator)) || rel == ".." {
+	if escapes(rel) {
 		return "", errors.New("path escapes base dir")
 	}
+
+	switch policy {
+	case symlinkFollow:
+		return joinedAbs, nil
+	case symlinkSkip:
+		// No element below baseDir may be a symlink. Checking stops at the
+		// first one that doesn't exist: nothing below it can be a link.
+		p := baseAbs
+		for _, elem := range strings.Split(rel, string(filepath.Separator)) {
+			p = filepath.Join(p, elem)
+			fi, err := os.Lstat(p)
+			if err != nil {
+				break
+			}
+			if fi.Mode()&fs.ModeSymlink != 0 {
+				return "", errors.New("path is a symlink")
+			}
+		}
+		return joinedAbs, nil
+	}
+
+	// Symlinks must not point outside the base dir. A path that doesn't exist
+	// yet can't be a symlink, so callers will simply fail to stat it.
+	if resolved, err := filepath.EvalSymlinks(joinedAbs); err == nil {
+		baseResolved, err := filepath.EvalSymlinks(baseAbs)
+		if err != nil {
+			return "", err
+		}
+		rel, err := filepath.Rel(baseResolved, resolved)
+		if err != nil {
+			return "", err
+		}
+		if escapes(rel) {
+			return "", errors.New("symlink escapes base dir")
+		}
+	}
 	return joinedAbs, nil
 }
 
+func escapes(rel string) bool {
+	return rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
 func urlPathEscape(s string) string {
 	repl := strings.NewReplacer(
 		"%", "%25",
@@ -344,185 +2330,99 @@ func urlPathEscape(s string) string {
 	return repl.Replace(s)
 }
 
-func securityHeaders(next http.Handler) http.Handler {
+// defaultCSP only allows the app's own resources.
+var defaultCSP = strings.Join([]string{
+	"default-src 'self'",
+	"img-src 'self' data:",
+	"style-src 'self'",
+	"script-src 'self'",
+	// The service worker (/sw.js) and web app manifest.
+	"worker-src 'self'",
+	"manifest-src 'self'",
+}, "; ")
+
+// securityHeaders sets the hardening headers on every response. csp is the
+// Content-Security-Policy to send; frameOptions the X-Frame-Options value,
+// or "" to omit the header so the app can be framed.
+func securityHeaders(csp, frameOptions string, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("X-Content-Type-Options", "nosniff")
-		w.Header().Set("X-Frame-Options", "DENY")
+		if frameOptions != "" {
+			w.Header().Set("X-Frame-Options", frameOptions)
+		}
 		w.Header().Set("Referrer-Policy", "no-referrer")
 		w.Header().Set("Permissions-Policy", "geolocation=(), microphone=(), camera=()")
-
-		w.Header().Set("Content-Security-Policy", strings.Join([]string{
-			"default-src 'self'",
-			"img-src 'self' data:",
-			"style-src 'self'",
-			"script-src 'self'",
-		}, "; "))
+		w.Header().Set("Content-Security-Policy", csp)
 
 		next.ServeHTTP(w, r)
 	})
 }
 
-func stableHash(photos []Photo) string {
-	h := sha256.New()
-	for _, p := range photos {
-		io.WriteString(h, p.Name)
-		io.WriteString(h, ":")
-		io.WriteString(h, strconv.FormatInt(p.Mtime, 10))
-		io.WriteString(h, "\n")
+// parseFrameOptions maps FRAME_OPTIONS to an X-Frame-Options value: DENY,
+// SAMEORIGIN, or "" (none) to drop the header.
+func parseFrameOptions(v string) (string, bool) {
+	switch strings.ToUpper(strings.TrimSpace(v)) {
+	case "DENY":
+		return "DENY", true
+	case "SAMEORIGIN":
+		return "SAMEORIGIN", true
+	case "NONE", "OFF":
+		return "", true
+	default:
+		return "", false
 	}
-	return hex.EncodeToString(h.Sum(nil))
 }
 
-// ---- Auth (shared token) ----
-
-func authMiddleware(token string, next http.Handler) http.Handler {
-	want := []byte(token)
-
+func httpsRedirect(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Let /healthz pass for infra health checks.
-		if r.URL.Path == "/healthz" {
+		if isProbablyHTTPS(r) || r.URL.Path == "/healthz" || r.URL.Path == "/readyz" {
 			next.ServeHTTP(w, r)
 			return
 		}
+		// 308 keeps the method and body, unlike 301/302.
+		http.Redirect(w, r, "https://"+r.Host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
 
-		// If user provides token via query string once, set cookie then redirect.
-		// Accept token=... or t=...
-		q := r.URL.Query()
-		if provided := firstNonEmpty(q.Get("token"), q.Get("t")); provided != "" {
-			if constantTimeEqual(want, []byte(provided)) {
-				setAuthCookie(w, r, token)
-
-				// Redirect to same URL with token removed (so you can bookmark clean URLs later).
-				cleanURL := *r.URL
-				cq := cleanURL.Query()
-				cq.Del("token")
-				cq.Del("t")
-				cleanURL.RawQuery = cq.Encode()
-
-				http.Redirect(w, r, cleanURL.String(), http.StatusFound)
-				return
-			}
-			// If they tried a token and it's wrong, fall through to unauthorized response.
+func stableHash(photos []Photo) string {
+	h := sha256.New()
+	for _, p := range photos {
+		io.WriteString(h, p.Name)
+		io.WriteString(h, ":")
+		io.WriteString(h, strconv.FormatInt(p.Mtime, 10))
+		if p.Weight > 1 {
+			io.WriteString(h, ":"+strconv.Itoa(p.Weight))
 		}
-
-		// Cookie auth
-		if c, err := r.Cookie(authCookieName); err == nil && c != nil {
-			if constantTimeEqual(want, []byte(c.Value)) {
-				next.ServeHTTP(w, r)
-				return
-			}
+		if p.Favorite {
+			io.WriteString(h, ":fav")
 		}
-
-		// Bearer token auth
-		if bearer := parseBearer(r.Header.Get("Authorization")); bearer != "" {
-			if constantTimeEqual(want, []byte(bearer)) {
-				next.ServeHTTP(w, r)
-				return
-			}
+		if p.Blurhash != "" {
+			io.WriteString(h, ":"+p.Blurhash)
 		}
-
-		unauthorized(w, r)
-	})
-}
-
-func setAuthCookie(w http.ResponseWriter, r *http.Request, token string) {
-	secure := isProbablyHTTPS(r)
-
-	http.SetCookie(w, &http.Cookie{
-		Name:     authCookieName,
-		Value:    token,
-		Path:     "/",
-		MaxAge:   authCookieMaxAgeSeconds,
-		HttpOnly: true,
-		SameSite: http.SameSiteLaxMode,
-		Secure:   secure,
-	})
-}
-
-func unauthorized(w http.ResponseWriter, r *http.Request) {
-	// Minimal, human-friendly response that works on TVs/kiosks.
-	w.Header().Set("Cache-Control", "no-store")
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	w.WriteHeader(http.StatusUnauthorized)
-
-	_, _ = io.WriteString(w, `<!doctype html>
-<html lang="en">
-<head>
-  <meta charset="utf-8"/>
-  <meta name="viewport" content="width=device-width,initial-scale=1"/>
-  <title>Frameserve · Unauthorized</title>
-  <link rel="icon" type="image/svg+xml" href="/static/camera.svg" />
-  <link rel="apple-touch-icon" href="/static/camera.svg" />
-  <meta name="theme-color" content="#000000" />
-  <link rel="stylesheet" href="/static/info.css" />
-</head>
-<body>
-  <div class="wrap">
-    <div class="card">
-      <h1>Unauthorized</h1>
-      <p>This Frameserve instance requires a shared access token.</p>
-      <p><strong>One-time setup on this device:</strong></p>
-      <p>Open this URL once (replace <code>YOURTOKEN</code>):</p>
-      <p><code>`+htmlEscape(r.URL.Path)+`?token=YOURTOKEN</code></p>
-      <p>After that, the device will stay logged in via a long-lived cookie.</p>
-      <p class="muted">If you cleared cookies or switched browsers, repeat the one-time setup.</p>
-      <div class="actions">
-        <a class="btn" href="/info">How it works</a>
-      </div>
-    </div>
-  </div>
-</body>
-</html>`)
-}
-
-func constantTimeEqual(a, b []byte) bool {
-	if len(a) != len(b) {
-		return false
-	}
-	return subtle.ConstantTimeCompare(a, b) == 1
-}
-
-func parseBearer(authz string) string {
-	authz = strings.TrimSpace(authz)
-	if authz == "" {
-		return ""
-	}
-	parts := strings.SplitN(authz, " ", 2)
-	if len(parts) != 2 {
-		return ""
-	}
-	if strings.ToLower(strings.TrimSpace(parts[0])) != "bearer" {
-		return ""
+		if p.Caption != "" {
+			io.WriteString(h, ":"+strconv.Quote(p.Caption))
+		}
+		io.WriteString(h, "\n")
 	}
-	return strings.TrimSpace(parts[1])
+	return hex.EncodeToString(h.Sum(nil))
 }
 
-func firstNonEmpty(a, b string) string {
-	if strings.TrimSpace(a) != "" {
-		return a
-	}
-	return b
+// photosETag is the validator for an /api/photos response. The (canonically
+// encoded) query is part of it so a client switching sort order or page never
+// revalidates a stale body.
+func photosETag(query string, photos []Photo) string {
+	sum := sha256.Sum256([]byte(query + "\n" + stableHash(photos)))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
 }
 
-func isProbablyHTTPS(r *http.Request) bool {
-	// Direct TLS
-	if r.TLS != nil {
-		return true
-	}
-	// Common reverse-proxy headers
-	if strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") {
-		return true
+// etagMatches reports whether an If-None-Match header value matches etag,
+// using the weak comparison RFC 9110 prescribes for GET/HEAD.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
 	}
 	return false
 }
-
-func htmlEscape(s string) string {
-	repl := strings.NewReplacer(
-		"&", "&amp;",
-		"<", "&lt;",
-		">", "&gt;",
-		`"`, "&quot;",
-		"'", "&#39;",
-	)
-	return repl.Replace(s)
-}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestIsIgnored(t *testing.T) {
+	defer func(p []string) { ignorePatterns = p }(ignorePatterns)
+	ignorePatterns = []string{"@eaDir", "*_edit.jpg", "Originals", "Thumbs.db"}
+
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"beach.jpg", false},
+		{".DS_Store", true},
+		{"._beach.jpg", true},
+		{".hidden.jpg", true},
+		{"vacation/._beach.jpg", true},
+		{".trash/beach.jpg", true},
+		{"@eaDir/x.jpg", true},
+		{"vacation/@eaDir/SYNOPHOTO_THUMB_XL.jpg", true},
+		{"beach_edit.jpg", true},
+		{"vacation/2024/beach_edit.jpg", true},
+		{"beach_edit.jpg.png", false},
+		{"Originals/beach.jpg", true},
+		{"vacation/Originals/beach.jpg", true},
+		{"originals/beach.jpg", false}, // patterns are case-sensitive
+		{"vacation/Originals.jpg", false},
+		{"Thumbs.db", true},
+		{"my.photos/beach.jpg", false},
+	}
+	for _, tt := range tests {
+		if got := isIgnored(tt.name); got != tt.want {
+			t.Errorf("isIgnored(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWalkPhotosSkipsIgnored(t *testing.T) {
+	defer func(p []string) { ignorePatterns = p }(ignorePatterns)
+	ignorePatterns = []string{"@eaDir", "*_edit.jpg"}
+
+	dir := t.TempDir()
+	for _, name := range []string{
+		"a.jpg",
+		"._a.jpg",
+		".hidden/b.jpg",
+		"@eaDir/x.jpg",
+		"a_edit.jpg",
+		"vacation/c.jpg",
+		"vacation/@eaDir/c.jpg",
+		"vacation/raw/d.jpg",
+		"vacation/keep.jpg",
+	} {
+		writeTestJPEG(t, dir, name, 8, 8)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ".DS_Store"), []byte("junk"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rules := "raw/\n"
+	if err := os.WriteFile(filepath.Join(dir, ignoreFileName), []byte(rules), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	err := walkPhotos(dir, true, func(p Photo) error {
+		got = append(got, p.Name)
+		return nil
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(got)
+	want := []string{"a.jpg", "vacation/c.jpg", "vacation/keep.jpg"}
+	if !slices.Equal(got, want) {
+		t.Errorf("listed %q, want %q", got, want)
+	}
+}
+
+func TestScanPhotosHashIsStable(t *testing.T) {
+	defer func(n int) { scanWorkers = n }(scanWorkers)
+	scanWorkers = 8
+
+	dir := t.TempDir()
+	for i := range 200 {
+		writeTestJPEG(t, dir, fmt.Sprintf("album%d/%03d.jpg", i%5, i), 4, 4)
+	}
+	store := &localStore{dir: dir, recursive: true}
+
+	var first string
+	for i := range 10 {
+		photos, _, err := scanPhotos(store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(photos) != 200 {
+			t.Fatalf("scan %d found %d photos, want 200", i, len(photos))
+		}
+		hash := stableHash(photos)
+		if i == 0 {
+			first = hash
+		} else if hash != first {
+			t.Fatalf("scan %d hash %s, first was %s", i, hash, first)
+		}
+	}
+}
+
+func TestSortPhotosTiesAreStable(t *testing.T) {
+	// A bulk copy: every photo has the same mtime, and names differ in case.
+	var photos []Photo
+	for _, name := range []string{"b.jpg", "A.jpg", "a.jpg", "c.jpg", "B.jpg", "vacation/a.jpg"} {
+		photos = append(photos, Photo{Name: name, Mtime: 1700000000})
+	}
+
+	for _, order := range []string{"mtime_desc", "mtime_asc", "exif_asc", "exif_desc", "name_asc", "name_desc", "album_balanced"} {
+		t.Run(order, func(t *testing.T) {
+			var wantNames []string
+			var wantETag string
+			rng := rand.New(rand.NewSource(1))
+			for i := 0; i < 20; i++ {
+				in := append([]Photo(nil), photos...)
+				rng.Shuffle(len(in), func(i, j int) { in[i], in[j] = in[j], in[i] })
+				out := sortPhotos(in, order)
+
+				names := make([]string, len(out))
+				for i, p := range out {
+					names[i] = p.Name
+				}
+				etag := photosETag("order="+order, out)
+				if i == 0 {
+					wantNames, wantETag = names, etag
+					continue
+				}
+				if !slices.Equal(names, wantNames) {
+					t.Fatalf("order changed with the input order:\n got %q\nwant %q", names, wantNames)
+				}
+				if etag != wantETag {
+					t.Fatalf("ETag changed with the input order: %s, want %s", etag, wantETag)
+				}
+			}
+		})
+	}
+
+	// Ties in mtime come out A to Z, case-insensitively; names that differ
+	// only in case in byte order.
+	out := sortPhotos(append([]Photo(nil), photos...), "mtime_desc")
+	var names []string
+	for _, p := range out {
+		names = append(names, p.Name)
+	}
+	want := []string{"A.jpg", "a.jpg", "B.jpg", "b.jpg", "c.jpg", "vacation/a.jpg"}
+	if !slices.Equal(names, want) {
+		t.Errorf("mtime_desc ties = %q, want %q", names, want)
+	}
+}
+
+func TestSymlinkPolicies(t *testing.T) {
+	base := t.TempDir()
+	outside := t.TempDir()
+	writeTestJPEG(t, base, "real.jpg", 8, 8)
+	writeTestJPEG(t, base, "album/inside.jpg", 8, 8)
+	writeTestJPEG(t, outside, "secret.jpg", 8, 8)
+	writeTestJPEG(t, outside, "library/far.jpg", 8, 8)
+	links := map[string]string{
+		"inside-link.jpg": filepath.Join(base, "real.jpg"),
+		"escape.jpg":      filepath.Join(outside, "secret.jpg"),
+		"linked-album":    filepath.Join(outside, "library"),
+		"album-link":      filepath.Join(base, "album"),
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(base, name)); err != nil {
+			t.Skipf("symlinks unavailable: %v", err)
+		}
+	}
+
+	tests := []struct {
+		policy string
+		// joinable are the names safeJoinWith lets through.
+		joinable map[string]bool
+		listed   []string
+	}{
+		{
+			policy: symlinkReject,
+			joinable: map[string]bool{
+				"real.jpg": true, "inside-link.jpg": true, "escape.jpg": false,
+				"linked-album/far.jpg": false, "album-link/inside.jpg": true, "../x.jpg": false,
+			},
+			listed: []string{"album/inside.jpg", "inside-link.jpg", "real.jpg"},
+		},
+		{
+			policy: symlinkFollow,
+			joinable: map[string]bool{
+				"real.jpg": true, "inside-link.jpg": true, "escape.jpg": true,
+				"linked-album/far.jpg": true, "album-link/inside.jpg": true, "../x.jpg": false,
+			},
+			listed: []string{"album-link/inside.jpg", "album/inside.jpg", "escape.jpg", "inside-link.jpg", "linked-album/far.jpg", "real.jpg"},
+		},
+		{
+			policy: symlinkSkip,
+			joinable: map[string]bool{
+				"real.jpg": true, "inside-link.jpg": false, "escape.jpg": false,
+				"linked-album/far.jpg": false, "album-link/inside.jpg": false, "../x.jpg": false,
+			},
+			listed: []string{"album/inside.jpg", "real.jpg"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			defer func(p string) { symlinkPolicy = p }(symlinkPolicy)
+			symlinkPolicy = tt.policy
+
+			for name, want := range tt.joinable {
+				_, err := safeJoinWith(base, name, tt.policy)
+				if got := err == nil; got != want {
+					t.Errorf("safeJoinWith(%q): err = %v, want ok = %v", name, err, want)
+				}
+			}
+
+			var listed []string
+			err := walkPhotos(base, true, func(p Photo) error {
+				listed = append(listed, p.Name)
+				return nil
+			}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(listed)
+			if !slices.Equal(listed, tt.listed) {
+				t.Errorf("listed %q, want %q", listed, tt.listed)
+			}
+
+			// Linked files are served exactly when they're listed.
+			for name := range links {
+				if !strings.HasSuffix(name, ".jpg") {
+					continue
+				}
+				_, _, ok := lookupPhoto(base, true, name)
+				if want := slices.Contains(tt.listed, name); ok != want {
+					t.Errorf("lookupPhoto(%q) = %v, want %v", name, ok, want)
+				}
+			}
+		})
+	}
+}
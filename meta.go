@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// ---- Photo metadata (dimensions, EXIF) ----
+
+type PhotoMeta struct {
+	Name   string `json:"name"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+	// ExifTime is the EXIF capture time (unix seconds).
+	ExifTime int64  `json:"exif_time,omitempty"`
+	Make     string `json:"make,omitempty"`
+	Model    string `json:"model,omitempty"`
+	GPS      *GPS   `json:"gps,omitempty"`
+	// Orientation is the EXIF Orientation tag (1-8); 0 when absent.
+	Orientation int `json:"orientation,omitempty"`
+}
+
+type GPS struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+// metaCache remembers parsed metadata per file path. An entry is only reused
+// while the file's mtime matches, so edited files get re-parsed.
+type metaCache struct {
+	mu      sync.Mutex
+	entries map[string]metaEntry
+}
+
+type metaEntry struct {
+	mtime int64
+	meta  PhotoMeta
+}
+
+var photoMetas = &metaCache{entries: make(map[string]metaEntry)}
+
+// get returns the metadata for fullPath (with Name left empty), parsing the
+// file only if it changed since the last call.
+func (c *metaCache) get(fullPath string, mtime int64) PhotoMeta {
+	m, _ := c.load(fullPath, mtime)
+	return m
+}
+
+// load is get, but fails if the file can't be opened. That isn't cached,
+// so a file whose permissions are fixed shows up on the next call.
+func (c *metaCache) load(fullPath string, mtime int64) (PhotoMeta, error) {
+	c.mu.Lock()
+	e, ok := c.entries[fullPath]
+	c.mu.Unlock()
+	if ok && e.mtime == mtime {
+		return e.meta, nil
+	}
+
+	m, err := readMeta(fullPath)
+	if err != nil {
+		return m, err
+	}
+
+	c.mu.Lock()
+	c.entries[fullPath] = metaEntry{mtime: mtime, meta: m}
+	c.mu.Unlock()
+	return m, nil
+}
+
+func hasExif(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".jpg", ".jpeg":
+		return true
+	default:
+		return false
+	}
+}
+
+// readMeta reads the image header and, for JPEGs, the EXIF block. Anything
+// that can't be determined is left at its zero value; only a file that
+// can't be opened is an error.
+func readMeta(fullPath string) (PhotoMeta, error) {
+	var m PhotoMeta
+
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return m, err
+	}
+	defer f.Close()
+
+	// DecodeConfig only reads the header, not the pixels.
+	if cfg, _, err := image.DecodeConfig(f); err == nil {
+		m.Width, m.Height = cfg.Width, cfg.Height
+	}
+
+	if !hasExif(fullPath) {
+		return m, nil
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return m, nil
+	}
+	x, err := exif.Decode(f)
+	if err != nil {
+		return m, nil
+	}
+
+	// DateTime prefers DateTimeOriginal and falls back to DateTime.
+	if t, err := x.DateTime(); err == nil && !t.IsZero() {
+		m.ExifTime = t.Unix()
+	}
+	m.Make = exifString(x, exif.Make)
+	m.Model = exifString(x, exif.Model)
+	if lat, lon, err := x.LatLong(); err == nil {
+		m.GPS = &GPS{Lat: lat, Lon: lon}
+	}
+	if tag, err := x.Get(exif.Orientation); err == nil {
+		if o, err := tag.Int(0); err == nil {
+			m.Orientation = o
+		}
+	}
+	return m, nil
+}
+
+func exifString(x *exif.Exif, name exif.FieldName) string {
+	tag, err := x.Get(name)
+	if err != nil {
+		return ""
+	}
+	s, err := tag.StringVal()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(s)
+}
+
+// aspectRatio is the displayed width/height, rounded to 4 places, or 0 if
+// the dimensions are unknown. Orientations 5-8 turn the image on its side.
+func (m PhotoMeta) aspectRatio() float64 {
+	if m.Width <= 0 || m.Height <= 0 {
+		return 0
+	}
+	w, h := float64(m.Width), float64(m.Height)
+	if m.Orientation >= 5 {
+		w, h = h, w
+	}
+	return math.Round(w/h*10000) / 10000
+}
+
+// effectiveTime is the capture time if known, otherwise the file mtime.
+func (p Photo) effectiveTime() int64 {
+	if p.ExifTime != 0 {
+		return p.ExifTime
+	}
+	return p.Mtime
+}
+
+const (
+	// maxMetaBatchNames and maxMetaBatchBytes bound a POST /api/photos/meta
+	// request: enough for any screenful, not for the whole library.
+	maxMetaBatchNames = 1000
+	maxMetaBatchBytes = 1 << 20
+)
+
+// metaBatchHandler answers POST /api/photos/meta: a JSON array of photo
+// names in, a JSON object of name → PhotoMeta out, so a client can fetch
+// metadata for many photos in one request. Names that aren't photos (bad
+// paths, missing files) are left out rather than failing the batch.
+func metaBatchHandler(dir string, recursive bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var names []string
+		r.Body = http.MaxBytesReader(w, r.Body, maxMetaBatchBytes)
+		if err := json.NewDecoder(r.Body).Decode(&names); err != nil {
+			http.Error(w, "expected a JSON array of photo names", http.StatusBadRequest)
+			return
+		}
+		if len(names) > maxMetaBatchNames {
+			http.Error(w, fmt.Sprintf("at most %d names per request", maxMetaBatchNames), http.StatusBadRequest)
+			return
+		}
+
+		metas := make(map[string]PhotoMeta, len(names))
+		for _, name := range names {
+			if _, done := metas[name]; done {
+				continue
+			}
+			// lookupPhoto applies safeJoin and the listing's rules.
+			fullPath, fi, ok := lookupPhoto(dir, recursive, name)
+			if !ok {
+				continue
+			}
+			meta := photoMetas.get(fullPath, fi.ModTime().Unix())
+			meta.Name = name
+			metas[name] = meta
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, metas)
+	}
+}
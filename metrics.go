@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ---- Metrics (Prometheus) ----
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "frameserve_http_requests_total",
+		Help: "HTTP requests by route and status code.",
+	}, []string{"path", "code"})
+
+	scanDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "frameserve_scan_duration_seconds",
+		Help:    "Time spent scanning the photos directory.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	photoCount = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "frameserve_photos",
+		Help: "Number of photos found by the most recent scan.",
+	})
+
+	scanErrorCount = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "frameserve_scan_errors",
+		Help: "Number of files the most recent scan couldn't read.",
+	})
+
+	methodNotAllowed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "frameserve_method_not_allowed_total",
+		Help: "Requests answered 405 by route and the method that was used.",
+	}, []string{"path", "method"})
+)
+
+func registerMetrics() {
+	prometheus.MustRegister(httpRequests, scanDuration, photoCount, scanErrorCount, methodNotAllowed)
+}
+
+// metricsMiddleware counts requests by the mux pattern they matched (rather
+// than the raw path) so per-photo URLs don't explode label cardinality.
+func metricsMiddleware(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		_, pattern := mux.Handler(r)
+		httpRequests.WithLabelValues(pattern, strconv.Itoa(rec.status)).Inc()
+		if rec.status == http.StatusMethodNotAllowed {
+			methodNotAllowed.WithLabelValues(pattern, metricMethod(r.Method)).Inc()
+		}
+	})
+}
+
+// metricMethod is the method as a label value. Clients can send any token
+// as a method, so nonstandard ones are lumped together.
+func metricMethod(m string) string {
+	switch m {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return m
+	default:
+		return "other"
+	}
+}
+
+// statusRecorder remembers the status code and body size written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	bytes       int64
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if !s.wroteHeader {
+		s.status = code
+		s.wroteHeader = true
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	s.wroteHeader = true
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += int64(n)
+	return n, err
+}
+
+// Flush passes through so streaming handlers can still flush.
+func (s *statusRecorder) Flush() {
+	s.wroteHeader = true
+	http.NewResponseController(s.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
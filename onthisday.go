@@ -0,0 +1,48 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// ---- On this day (/api/photos/onthisday) ----
+
+// photoDate is when a photo was taken: its EXIF time, or its mtime for
+// files without one.
+func photoDate(p Photo) time.Time {
+	if p.ExifTime != 0 {
+		return time.Unix(p.ExifTime, 0)
+	}
+	return time.Unix(p.Mtime, 0)
+}
+
+// onThisDay keeps the photos taken on now's month and day in earlier
+// years, newest year first. In years without a February 29, photos from
+// that day show up on the 28th instead.
+func onThisDay(photos []Photo, now time.Time) []Photo {
+	month, day := now.Month(), now.Day()
+	leapDay := month == time.February && day == 28 && !isLeapYear(now.Year())
+
+	var matched []Photo
+	for _, p := range photos {
+		t := photoDate(p).In(now.Location())
+		if t.Year() >= now.Year() || t.Month() != month {
+			continue
+		}
+		if t.Day() == day || (leapDay && t.Day() == 29) {
+			matched = append(matched, p)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		ti, tj := photoDate(matched[i]), photoDate(matched[j])
+		if !ti.Equal(tj) {
+			return ti.After(tj)
+		}
+		return compareNames(matched[i], matched[j]) < 0
+	})
+	return matched
+}
+
+func isLeapYear(year int) bool {
+	return year%4 == 0 && (year%100 != 0 || year%400 == 0)
+}
@@ -0,0 +1,33 @@
+package main
+
+import "net/http"
+
+// ---- OPTIONS ----
+
+// optionsMiddleware answers OPTIONS requests with a 204 and the methods the
+// route supports. methods gets the mux pattern the path matched (and the
+// path, for routes whose methods vary below it) and returns those methods,
+// or "" for a path that isn't a route. No handler runs for an OPTIONS
+// request, so none can act on one.
+//
+// It runs outside auth: the answer only depends on the route, never on
+// whether a photo exists, and CORS preflights carry no credentials.
+func optionsMiddleware(mux *http.ServeMux, methods func(pattern, path string) string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		allow := ""
+		if _, pattern := mux.Handler(r); pattern != "" {
+			allow = methods(pattern, r.URL.Path)
+		}
+		if allow == "" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Allow", allow+", OPTIONS")
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// ---- Crash-safe writes ----
+
+// writeFileAtomic writes b to a temp file next to dst and renames it into
+// place, so readers never observe a partially written file. The data is
+// fsynced before the rename and the directory after it: frames lose power
+// a lot, and without that a crash can leave dst empty even though the
+// rename "happened".
+func writeFileAtomic(dst string, b []byte) error {
+	return writeFileRenamed(dst, b, true)
+}
+
+// writeCacheFile is writeFileAtomic without the fsyncs, for files that can
+// be regenerated (cached thumbnails): readers still never see half a file,
+// but one lost to a crash is simply made again.
+func writeCacheFile(dst string, b []byte) error {
+	return writeFileRenamed(dst, b, false)
+}
+
+func writeFileRenamed(dst string, b []byte, durable bool) error {
+	dir := filepath.Dir(dst)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	// After a successful rename this fails harmlessly.
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if durable {
+		if err := tmp.Sync(); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return err
+	}
+	if durable {
+		syncDir(dir)
+	}
+	return nil
+}
+
+// writeJSONAtomic saves v as indented JSON with writeFileAtomic. Anything
+// frameserve persists as JSON goes through here, so an interrupted save
+// leaves the previous version intact.
+func writeJSONAtomic(path string, v any) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(b, '\n'))
+}
+
+// syncDir makes a rename in dir durable. Some platforms and filesystems
+// can't fsync a directory; the rename is still atomic there, so errors
+// are ignored.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	writers := []struct {
+		name  string
+		write func(string, []byte) error
+	}{
+		{"durable", writeFileAtomic},
+		{"cache", writeCacheFile},
+	}
+	for _, w := range writers {
+		t.Run(w.name, func(t *testing.T) {
+			t.Run("readers never see a partial file", func(t *testing.T) {
+				dst := filepath.Join(t.TempDir(), "state.json")
+				versions := [][]byte{
+					bytes.Repeat([]byte("a"), 256<<10),
+					bytes.Repeat([]byte("b"), 512<<10),
+				}
+				if err := w.write(dst, versions[0]); err != nil {
+					t.Fatal(err)
+				}
+
+				done := make(chan struct{})
+				var wg sync.WaitGroup
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for {
+						select {
+						case <-done:
+							return
+						default:
+						}
+						got, err := os.ReadFile(dst)
+						if err != nil {
+							t.Error(err)
+							return
+						}
+						if !bytes.Equal(got, versions[0]) && !bytes.Equal(got, versions[1]) {
+							t.Errorf("read %d bytes that are neither version", len(got))
+							return
+						}
+					}
+				}()
+				for i := 0; i < 50; i++ {
+					if err := w.write(dst, versions[i%2]); err != nil {
+						t.Error(err)
+						break
+					}
+				}
+				close(done)
+				wg.Wait()
+			})
+
+			t.Run("a failed write keeps the old file and leaves no temp", func(t *testing.T) {
+				dir := t.TempDir()
+				// A non-empty directory in dst's place makes the rename fail
+				// after the temp file has been written.
+				dst := filepath.Join(dir, "state.json")
+				if err := os.MkdirAll(filepath.Join(dst, "keep"), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := w.write(dst, []byte("new")); err == nil {
+					t.Fatal("write over a directory succeeded")
+				}
+				if fi, err := os.Stat(filepath.Join(dst, "keep")); err != nil || !fi.IsDir() {
+					t.Errorf("old contents gone: %v", err)
+				}
+				assertNoTemps(t, dir)
+			})
+
+			t.Run("a crash mid-write leaves the old file intact", func(t *testing.T) {
+				dir := t.TempDir()
+				dst := filepath.Join(dir, "state.json")
+				if err := w.write(dst, []byte("old")); err != nil {
+					t.Fatal(err)
+				}
+				// What a power cut during the next write leaves behind: a
+				// truncated temp file that was never renamed.
+				if err := os.WriteFile(filepath.Join(dir, ".tmp-123"), []byte("ne"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				if got, err := os.ReadFile(dst); err != nil || string(got) != "old" {
+					t.Fatalf("dst = %q, %v; want %q", got, err, "old")
+				}
+				// And the next write still works.
+				if err := w.write(dst, []byte("new")); err != nil {
+					t.Fatal(err)
+				}
+				if got, err := os.ReadFile(dst); err != nil || string(got) != "new" {
+					t.Fatalf("dst = %q, %v; want %q", got, err, "new")
+				}
+			})
+		})
+	}
+}
+
+func assertNoTemps(t *testing.T, dir string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".tmp-") {
+			t.Errorf("temp file %s left behind", e.Name())
+		}
+	}
+}
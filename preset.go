@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ---- Presets (/api/preset, ?preset=) ----
+
+// presetParams are the slideshow URL params a preset can carry. Each
+// normalizer checks a value and returns it in the form stored in the
+// token, or false if the slideshow wouldn't accept it.
+var presetParams = map[string]func(string) (string, bool){
+	"order":      oneOf(sortOrders...),
+	"seconds":    intBetween(1, 3600),
+	"refresh":    intBetween(5, 3600),
+	"maxpixels":  intBetween(1, 1<<62),
+	"fit":        oneOf("contain", "cover"),
+	"transition": oneOf("fade", "none"),
+	"album":      presetAlbum,
+	"shuffle":    presetBool,
+	"captions":   presetBool,
+	"hud":        presetBool,
+	"watch":      presetBool,
+	"awake":      presetBool,
+	"sync":       presetBool,
+}
+
+// defaultPresetKey signs presets when there is neither PRESET_KEY nor an
+// auth token. Such presets still can't be mistyped or truncated unnoticed,
+// but anyone can make one, which without auth anyone could anyway.
+const defaultPresetKey = "frameserve-preset"
+
+type PresetResponse struct {
+	Preset string `json:"preset"`
+	// URL opens the slideshow with the preset.
+	URL    string            `json:"url"`
+	Params map[string]string `json:"params"`
+}
+
+// normalizePreset checks slideshow params for a preset, returning them in
+// canonical form. Empty values are dropped; unknown params are an error so
+// a typo doesn't go unnoticed until the frame shows the wrong thing.
+func normalizePreset(q url.Values) (url.Values, error) {
+	out := url.Values{}
+	for k, vs := range q {
+		normalize, ok := presetParams[k]
+		if !ok {
+			return nil, fmt.Errorf("%s can't be part of a preset", k)
+		}
+		v := strings.TrimSpace(vs[len(vs)-1])
+		if v == "" {
+			continue
+		}
+		if v, ok = normalize(v); !ok {
+			return nil, fmt.Errorf("invalid %s=%q", k, vs[len(vs)-1])
+		}
+		out.Set(k, v)
+	}
+	if len(out) == 0 {
+		return nil, errors.New("a preset needs at least one param")
+	}
+	return out, nil
+}
+
+// encodePreset turns normalized params into a token: the URL-encoded
+// params and an HMAC of them, both base64url, joined by ".".
+func encodePreset(key []byte, params url.Values) string {
+	payload := []byte(params.Encode())
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + presetSig(key, payload)
+}
+
+// decodePreset checks a token's signature and params.
+func decodePreset(key []byte, token string) (url.Values, error) {
+	enc, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, errors.New("malformed preset")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(enc)
+	if err != nil || !hmac.Equal([]byte(sig), []byte(presetSig(key, payload))) {
+		return nil, errors.New("invalid preset")
+	}
+	q, err := url.ParseQuery(string(payload))
+	if err != nil {
+		return nil, errors.New("invalid preset")
+	}
+	return normalizePreset(q)
+}
+
+// presetSig is the first 16 bytes of the HMAC-SHA256, plenty against
+// forgery and short enough to keep URLs pasteable.
+func presetSig(key, payload []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// applyPreset is the /api/config body with a preset's params in place of
+// the server defaults they correspond to.
+func applyPreset(cfg ConfigResponse, params url.Values) ConfigResponse {
+	if v := params.Get("seconds"); v != "" {
+		n, _ := strconv.Atoi(v)
+		cfg.SlideIntervalMS = n * 1000
+	}
+	if v := params.Get("transition"); v != "" {
+		cfg.Transition = v
+	}
+	if v := params.Get("captions"); v != "" {
+		cfg.ShowCaptions = v == "1"
+	}
+	if v := params.Get("order"); v != "" {
+		cfg.DefaultOrder = v
+	}
+	cfg.Preset = make(map[string]string, len(params))
+	for k := range params {
+		cfg.Preset[k] = params.Get(k)
+	}
+	return cfg
+}
+
+func oneOf(allowed ...string) func(string) (string, bool) {
+	return func(v string) (string, bool) {
+		v = strings.ToLower(v)
+		return v, slices.Contains(allowed, v)
+	}
+}
+
+func intBetween(lo, hi int64) func(string) (string, bool) {
+	return func(v string) (string, bool) {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < lo || n > hi {
+			return "", false
+		}
+		return strconv.FormatInt(n, 10), true
+	}
+}
+
+// presetBool accepts what the slideshow's truthy() does, plus the
+// negations, and stores "1" or "0".
+func presetBool(v string) (string, bool) {
+	switch strings.ToLower(v) {
+	case "1", "true", "yes", "on":
+		return "1", true
+	case "0", "false", "no", "off":
+		return "0", true
+	}
+	return "", false
+}
+
+// presetAlbum accepts a clean relative folder path; whether it exists is
+// only known when the frame asks for its photos.
+func presetAlbum(v string) (string, bool) {
+	v = strings.Trim(v, "/")
+	return v, v != "" && !strings.Contains(v, `\`) && path.Clean("/"+v) == "/"+v
+}
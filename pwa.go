@@ -0,0 +1,53 @@
+package main
+
+import "net/http"
+
+// ---- Installable app (/manifest.json, /sw.js) ----
+
+// WebManifest is the web app manifest that lets browsers install the
+// slideshow as a fullscreen app (APP_NAME, THEME_COLOR).
+type WebManifest struct {
+	Name            string         `json:"name"`
+	ShortName       string         `json:"short_name"`
+	StartURL        string         `json:"start_url"`
+	Scope           string         `json:"scope"`
+	Display         string         `json:"display"`
+	BackgroundColor string         `json:"background_color"`
+	ThemeColor      string         `json:"theme_color"`
+	Icons           []ManifestIcon `json:"icons"`
+}
+
+type ManifestIcon struct {
+	Src     string `json:"src"`
+	Sizes   string `json:"sizes"`
+	Type    string `json:"type"`
+	Purpose string `json:"purpose,omitempty"`
+}
+
+func newWebManifest(name, themeColor string) WebManifest {
+	return WebManifest{
+		Name:            name,
+		ShortName:       name,
+		StartURL:        "/",
+		Scope:           "/",
+		Display:         "fullscreen",
+		BackgroundColor: "#000000",
+		ThemeColor:      themeColor,
+		Icons: []ManifestIcon{
+			{Src: "/static/camera.svg", Sizes: "any", Type: "image/svg+xml", Purpose: "any"},
+		},
+	}
+}
+
+// serveManifest answers GET /manifest.json.
+func serveManifest(m WebManifest) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+		writeJSON(w, m)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// ---- Auth rate limiting ----
+
+const (
+	// authFailureBurst failed attempts are allowed back to back; after that a
+	// client gets one more try every authFailureInterval.
+	authFailureBurst    = 10
+	authFailureInterval = 10 * time.Second
+	// Limiter entries idle this long have refilled completely and are dropped.
+	authLimiterIdle = 10 * time.Minute
+)
+
+// authLimiter throttles failed auth attempts per client IP so the shared
+// token can't be brute-forced. Successful requests never consume attempts.
+type authLimiter struct {
+	mu        sync.Mutex
+	clients   map[string]*authLimiterEntry
+	lastSweep time.Time
+}
+
+type authLimiterEntry struct {
+	lim      *rate.Limiter
+	lastSeen time.Time
+}
+
+func newAuthLimiter() *authLimiter {
+	return &authLimiter{clients: make(map[string]*authLimiterEntry)}
+}
+
+// blocked reports whether key has used up its failed attempts, and if so how
+// long until it may try again.
+func (l *authLimiter) blocked(key string) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e, ok := l.clients[key]
+	if !ok {
+		return 0, false
+	}
+	tokens := e.lim.TokensAt(time.Now())
+	if tokens >= 1 {
+		return 0, false
+	}
+	return time.Duration((1 - tokens) * float64(authFailureInterval)), true
+}
+
+// fail records a failed attempt for key.
+func (l *authLimiter) fail(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+
+	e, ok := l.clients[key]
+	if !ok {
+		e = &authLimiterEntry{lim: rate.NewLimiter(rate.Every(authFailureInterval), authFailureBurst)}
+		l.clients[key] = e
+	}
+	e.lastSeen = now
+	e.lim.AllowN(now, 1)
+}
+
+// sweep drops idle entries so the map doesn't grow without bound. It runs at
+// most once a minute; l.mu must be held.
+func (l *authLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for k, e := range l.clients {
+		if now.Sub(e.lastSeen) > authLimiterIdle {
+			delete(l.clients, k)
+		}
+	}
+}
+
+// rateLimitKey identifies the client behind r (see clientIP for when
+// forwarding headers are believed).
+func rateLimitKey(r *http.Request) string {
+	return clientAddr(r)
+}
+
+func tooManyAttempts(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds()+0.5)))
+	http.Error(w, "too many failed attempts; try again later", http.StatusTooManyRequests)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+)
+
+// ---- Request IDs (X-Request-ID) ----
+
+type requestIDKey struct{}
+
+// requestIDMiddleware gives every request an ID: the caller's X-Request-ID
+// if it sent a sane one (so a proxy's IDs carry through), otherwise a new
+// UUID. It is echoed in the response and logged with the request.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newUUID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// requestID returns the ID requestIDMiddleware stored in ctx, or "".
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// logRequestf logs like log.Printf, tagged with the request's ID.
+func logRequestf(r *http.Request, format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	id := requestID(r.Context())
+	if id == "" {
+		log.Print(msg)
+		return
+	}
+	slog.InfoContext(r.Context(), msg, "request_id", id)
+}
+
+// validRequestID accepts up to 128 visible ASCII characters, which keeps
+// client-supplied IDs from forging log lines or bloating them.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"slices"
+	"time"
+)
+
+// ---- Rotation (POST /api/photos/<name>/rotate, ALLOW_EDIT) ----
+
+// rotateJPEGQuality is used when re-encoding rotated originals; higher than
+// thumbQuality since the result replaces the photo.
+const rotateJPEGQuality = 92
+
+type RotateResponse struct {
+	Name string `json:"name"`
+	// URL carries the new cache-busting v= so clients reload the image.
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+var errRotateUnsupported = errors.New("only JPEG and PNG photos can be rotated")
+
+// rotateOrientations maps a clockwise turn in degrees to the EXIF
+// orientation that applyOrientation undoes with that same turn.
+var rotateOrientations = map[string]int{"90": 6, "180": 3, "270": 8}
+
+// rotatePhoto turns the photo at fullPath clockwise (orientation from
+// rotateOrientations) and atomically replaces it, returning the new size.
+// JPEGs are first made upright per their EXIF Orientation, and keep their
+// EXIF block with the tag reset, so the capture time and camera survive.
+// The mtime always moves forward so v= and derived caches change.
+func rotatePhoto(fullPath string, fi os.FileInfo, orientation, turn int) (int, int, error) {
+	orig, err := os.ReadFile(fullPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	img, format, err := image.Decode(bytes.NewReader(orig))
+	if errors.Is(err, image.ErrFormat) {
+		return 0, 0, errRotateUnsupported
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var buf bytes.Buffer
+	switch format {
+	case "jpeg":
+		img = applyOrientation(applyOrientation(img, orientation), turn)
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: rotateJPEGQuality}); err != nil {
+			return 0, 0, err
+		}
+	case "png":
+		img = applyOrientation(img, turn)
+		if err := png.Encode(&buf, img); err != nil {
+			return 0, 0, err
+		}
+	default:
+		return 0, 0, errRotateUnsupported
+	}
+
+	out := buf.Bytes()
+	if seg := jpegExifSegment(orig); seg != nil && format == "jpeg" {
+		resetExifOrientation(seg)
+		// Right after SOI, where EXIF belongs.
+		out = slices.Concat(out[:2], seg, out[2:])
+	}
+	if err := writeFileAtomic(fullPath, out); err != nil {
+		return 0, 0, err
+	}
+	if err := os.Chmod(fullPath, fi.Mode().Perm()); err != nil {
+		return 0, 0, err
+	}
+	// Within the same second the unix mtime wouldn't change.
+	if mtime := fi.ModTime().Unix(); time.Now().Unix() <= mtime {
+		t := time.Unix(mtime+1, 0)
+		if err := os.Chtimes(fullPath, t, t); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	b := img.Bounds()
+	return b.Dx(), b.Dy(), nil
+}
+
+// jpegExifSegment returns a copy of the APP1 "Exif" segment (marker
+// included) of a JPEG file, or nil if it has none.
+func jpegExifSegment(b []byte) []byte {
+	if len(b) < 4 || b[0] != 0xFF || b[1] != 0xD8 {
+		return nil
+	}
+	for i := 2; i+4 <= len(b) && b[i] == 0xFF; {
+		marker := b[i+1]
+		if marker == 0xDA { // start of scan: no more metadata
+			return nil
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(b[i+2:]))
+		if end > len(b) {
+			return nil
+		}
+		if marker == 0xE1 && bytes.HasPrefix(b[i+4:end], []byte("Exif\x00\x00")) {
+			return append([]byte(nil), b[i:end]...)
+		}
+		i = end
+	}
+	return nil
+}
+
+// resetExifOrientation sets the Orientation tag in IFD0 of an APP1 Exif
+// segment (from jpegExifSegment) to 1, in place. Malformed data is left as is.
+func resetExifOrientation(seg []byte) {
+	if len(seg) < 18 {
+		return
+	}
+	tiff := seg[10:]
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return
+	}
+	n := int(order.Uint16(tiff[ifd:]))
+	for k := 0; k < n; k++ {
+		e := ifd + 2 + 12*k
+		if e+12 > len(tiff) {
+			return
+		}
+		if order.Uint16(tiff[e:]) == 0x0112 {
+			order.PutUint16(tiff[e+8:], 1)
+			return
+		}
+	}
+}
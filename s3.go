@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ---- S3-compatible storage (STORAGE=s3) ----
+
+// emptySHA256 is the payload hash of a request without a body.
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// s3Store reads photos from a bucket on AWS S3 or a compatible server
+// (MinIO, Garage, …), using path-style URLs (endpoint/bucket/key) and
+// Signature V4. Photo names are object keys relative to prefix.
+type s3Store struct {
+	endpoint  *url.URL
+	bucket    string
+	prefix    string // "" or ending in "/"
+	region    string
+	accessKey string
+	secretKey string
+	recursive bool
+	client    *http.Client
+}
+
+func newS3Store(endpoint, bucket, prefix, region, accessKey, secretKey string, recursive bool) (*s3Store, error) {
+	u, err := url.Parse(strings.TrimRight(endpoint, "/"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("endpoint %q must be an http(s) URL", endpoint)
+	}
+	if bucket == "" {
+		return nil, errors.New("bucket is required")
+	}
+	if prefix = strings.Trim(prefix, "/"); prefix != "" {
+		prefix += "/"
+	}
+	return &s3Store{
+		endpoint:  u,
+		bucket:    bucket,
+		prefix:    prefix,
+		region:    region,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		recursive: recursive,
+		client:    &http.Client{Transport: s3Transport()},
+	}, nil
+}
+
+// s3Transport bounds connecting and waiting for a response, but not reading
+// the body: a Client.Timeout would cut off a long download or video stream
+// partway. Reads end with the request's context instead.
+func s3Transport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = (&net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+	t.ResponseHeaderTimeout = time.Minute
+	return t
+}
+
+// List calls fn for every servable object. Formats that would need
+// transcoding are skipped: that needs the file on local disk. Objects are
+// only read when served, so errs never gets anything; a failed listing
+// request fails the whole listing.
+func (s *s3Store) List(fn func(Photo) error, _ *scanErrors) error {
+	q := url.Values{"list-type": {"2"}}
+	if s.prefix != "" {
+		q.Set("prefix", s.prefix)
+	}
+	if !s.recursive {
+		q.Set("delimiter", "/")
+	}
+
+	for {
+		var page struct {
+			IsTruncated           bool
+			NextContinuationToken string
+			Contents              []struct {
+				Key          string
+				LastModified time.Time
+				Size         int64
+			}
+		}
+		resp, err := s.do(context.Background(), http.MethodGet, "", q, nil)
+		if err != nil {
+			return err
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("s3 list: %w", err)
+		}
+
+		for _, obj := range page.Contents {
+			name := strings.TrimPrefix(obj.Key, s.prefix)
+			if !s.validName(name) || needsTranscode(name) || tooBig(name, obj.Size) {
+				continue
+			}
+			mtime := obj.LastModified.Unix()
+			err := fn(Photo{
+				URL:   fmt.Sprintf("/photos/%s?v=%d", urlPathEscape(name), mtime),
+				Name:  name,
+				Mtime: mtime,
+				Size:  obj.Size,
+				Kind:  photoKind(name),
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return nil
+		}
+		q.Set("continuation-token", page.NextContinuationToken)
+	}
+}
+
+// validName applies the same rules lookupPhoto does for local files.
+func (s *s3Store) validName(name string) bool {
+	if name == "" || strings.Contains(name, `\`) || (!s.recursive && strings.Contains(name, "/")) {
+		return false
+	}
+	if path.Clean("/"+name) != "/"+name {
+		return false
+	}
+	return isAllowedExt(path.Base(name)) && !isIgnored(name)
+}
+
+func (s *s3Store) Stat(ctx context.Context, name string) (fs.FileInfo, error) {
+	if !s.validName(name) || needsTranscode(name) {
+		return nil, fs.ErrInvalid
+	}
+	resp, err := s.do(ctx, http.MethodHead, s.prefix+name, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	fi, err := s.fileInfo(name, resp)
+	if err == nil && tooBig(name, fi.Size()) {
+		return nil, fs.ErrNotExist
+	}
+	return fi, err
+}
+
+func (s *s3Store) Open(ctx context.Context, name string) (io.ReadSeekCloser, fs.FileInfo, error) {
+	fi, err := s.Stat(ctx, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &s3Object{ctx: ctx, store: s, key: s.prefix + name, size: fi.Size()}, fi, nil
+}
+
+func (s *s3Store) fileInfo(name string, resp *http.Response) (fs.FileInfo, error) {
+	size, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("s3 %s: missing Content-Length", name)
+	}
+	mtime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return s3FileInfo{name: path.Base(name), size: size, mtime: mtime}, nil
+}
+
+// do sends a signed request for key ("" for the bucket itself), abandoning
+// it (body included) when ctx ends. Missing objects come back as
+// fs.ErrNotExist and other failures as errors.
+func (s *s3Store) do(ctx context.Context, method, key string, q url.Values, header http.Header) (*http.Response, error) {
+	u := *s.endpoint
+	u.Path = strings.TrimRight(u.Path, "/") + "/" + s.bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawQuery = canonicalQuery(q)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	signV4(req, s.accessKey, s.secretKey, s.region, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		resp.Body.Close()
+		return nil, fs.ErrNotExist
+	case resp.StatusCode >= 300:
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, fmt.Errorf("s3 %s %s: %s: %s", method, u.Path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// s3Object reads an object lazily with Range requests, so seeking (as
+// http.ServeContent does for Range and to find the size) costs nothing and
+// only the bytes actually read are fetched. Reads stop when ctx (the
+// request's, normally) ends.
+type s3Object struct {
+	ctx   context.Context
+	store *s3Store
+	key   string
+	size  int64
+	off   int64
+	body  io.ReadCloser
+}
+
+func (o *s3Object) Read(p []byte) (int, error) {
+	if o.off >= o.size {
+		return 0, io.EOF
+	}
+	if o.body == nil {
+		h := http.Header{"Range": {fmt.Sprintf("bytes=%d-", o.off)}}
+		resp, err := o.store.do(o.ctx, http.MethodGet, o.key, nil, h)
+		if err != nil {
+			return 0, err
+		}
+		o.body = resp.Body
+	}
+	n, err := o.body.Read(p)
+	o.off += int64(n)
+	return n, err
+}
+
+func (o *s3Object) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = o.off + offset
+	case io.SeekEnd:
+		abs = o.size + offset
+	default:
+		return 0, errors.New("s3: invalid whence")
+	}
+	if abs < 0 {
+		return 0, errors.New("s3: negative position")
+	}
+	if abs != o.off && o.body != nil {
+		o.body.Close()
+		o.body = nil
+	}
+	o.off = abs
+	return abs, nil
+}
+
+func (o *s3Object) Close() error {
+	if o.body != nil {
+		return o.body.Close()
+	}
+	return nil
+}
+
+type s3FileInfo struct {
+	name  string
+	size  int64
+	mtime time.Time
+}
+
+func (fi s3FileInfo) Name() string       { return fi.name }
+func (fi s3FileInfo) Size() int64        { return fi.size }
+func (fi s3FileInfo) Mode() fs.FileMode  { return 0o444 }
+func (fi s3FileInfo) ModTime() time.Time { return fi.mtime }
+func (fi s3FileInfo) IsDir() bool        { return false }
+func (fi s3FileInfo) Sys() any           { return nil }
+
+// signV4 adds AWS Signature Version 4 headers for an unsigned-body S3
+// request. Host, Range and all x-amz-* headers are signed.
+func signV4(req *http.Request, accessKey, secretKey, region string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptySHA256)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		lk := strings.ToLower(k)
+		if strings.HasPrefix(lk, "x-amz-") || lk == "range" {
+			headers[lk] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, k := range names {
+		canonHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncodePath(req.URL.Path),
+		req.URL.RawQuery,
+		canonHeaders.String(),
+		signedHeaders,
+		emptySHA256,
+	}, "\n")
+
+	scope := day + "/" + region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	sig := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, sig))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	io.WriteString(mac, data)
+	return mac.Sum(nil)
+}
+
+// canonicalQuery encodes q the way SigV4 wants it: sorted, with spaces as
+// %20 rather than +. Used for the request URL too, so both match.
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		for _, v := range q[k] {
+			parts = append(parts, s3Escape(k)+"="+s3Escape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncodePath escapes each segment of an (unescaped) URL path.
+func uriEncodePath(p string) string {
+	segs := strings.Split(p, "/")
+	for i, s := range segs {
+		segs[i] = s3Escape(s)
+	}
+	return strings.Join(segs, "/")
+}
+
+// s3Escape percent-encodes everything except the RFC 3986 unreserved
+// characters, as SigV4 requires.
+func s3Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
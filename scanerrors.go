@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"sort"
+	"sync"
+)
+
+// ---- Scan errors (/api/stats) ----
+
+// maxScanErrors caps how many errors /api/stats lists; scan_error_count
+// still counts them all.
+const maxScanErrors = 100
+
+// ScanError is a file or folder the latest scan left out because it
+// couldn't be read, e.g. for its permissions.
+type ScanError struct {
+	Name  string `json:"name"`
+	Error string `json:"error"`
+}
+
+// scanErrors collects a scan's per-file errors, keyed by photo name. Files
+// are statted from several goroutines, hence the lock. Adding to a nil
+// *scanErrors does nothing, for callers outside a scan.
+type scanErrors struct {
+	mu     sync.Mutex
+	byName map[string]string
+}
+
+func (e *scanErrors) add(name string, err error) {
+	if e == nil {
+		return
+	}
+	// The full path would only repeat PHOTOS_DIR.
+	msg := err.Error()
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		msg = pe.Op + ": " + pe.Err.Error()
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.byName == nil {
+		e.byName = make(map[string]string)
+	}
+	e.byName[name] = msg
+}
+
+func (e *scanErrors) count() int {
+	if e == nil {
+		return 0
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.byName)
+}
+
+// all returns every error, in name order.
+func (e *scanErrors) all() []ScanError {
+	if e == nil {
+		return nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	out := make([]ScanError, 0, len(e.byName))
+	for name, msg := range e.byName {
+		out = append(out, ScanError{Name: name, Error: msg})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// list is all, cut to the first maxScanErrors.
+func (e *scanErrors) list() []ScanError {
+	out := e.all()
+	return out[:min(len(out), maxScanErrors)]
+}
+
+// logNew logs the errors that prev (the previous scan's) didn't have, so
+// a file that stays unreadable is reported once rather than every rescan.
+func (e *scanErrors) logNew(prev *scanErrors) {
+	seen := make(map[ScanError]bool)
+	for _, se := range prev.all() {
+		seen[se] = true
+	}
+	for _, se := range e.all() {
+		if !seen[se] {
+			slog.Warn("scan error", "name", se.Name, "error", se.Error)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ---- Share links (signed, expiring /photos/ URLs) ----
+
+const (
+	defaultShareTTL = time.Hour
+	maxShareTTL     = 30 * 24 * time.Hour
+)
+
+type ShareResponse struct {
+	URL string `json:"url"`
+	// Expires is when the link stops working (unix seconds).
+	Expires int64 `json:"expires"`
+}
+
+// signPhoto is the share signature for name (as in /photos/<name>) valid
+// until exp: hex HMAC-SHA256 of "name\nexp", keyed with the auth token.
+func signPhoto(key []byte, name string, exp int64) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s\n%d", name, exp)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// shareURL builds the signed link for name, valid until exp.
+func shareURL(key []byte, name string, exp int64) string {
+	q := url.Values{}
+	q.Set("exp", strconv.FormatInt(exp, 10))
+	q.Set("sig", signPhoto(key, name, exp))
+	return "/photos/" + urlPathEscape(name) + "?" + q.Encode()
+}
+
+// validShareSig reports whether ?exp= and ?sig= form an unexpired signature
+// for name.
+func validShareSig(key []byte, name, expParam, sig string, now time.Time) bool {
+	if len(key) == 0 || sig == "" {
+		return false
+	}
+	exp, err := strconv.ParseInt(expParam, 10, 64)
+	if err != nil || now.Unix() > exp {
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(signPhoto(key, name, exp)))
+}
+
+// parseShareTTL reads ?ttl= (a Go duration like "2h", or seconds), falling
+// back to defaultShareTTL and capping at maxShareTTL.
+func parseShareTTL(v string) (time.Duration, error) {
+	if v == "" {
+		return defaultShareTTL, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		secs, serr := strconv.Atoi(v)
+		if serr != nil {
+			return 0, fmt.Errorf("ttl must be a duration like 2h or a number of seconds")
+		}
+		d = time.Duration(secs) * time.Second
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("ttl must be positive")
+	}
+	return min(d, maxShareTTL), nil
+}
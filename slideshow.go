@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ---- Synced slideshow (SLIDESHOW_INTERVAL) ----
+
+// SlideshowState is what every synced frame should be showing.
+type SlideshowState struct {
+	// Photo is nil while there are no photos.
+	Photo *Photo `json:"photo"`
+	Index int    `json:"index"`
+	Count int    `json:"count"`
+	// ChangedAt and NextChange are unix milliseconds.
+	ChangedAt  int64 `json:"changed_at_ms"`
+	NextChange int64 `json:"next_change_ms"`
+}
+
+// slideshow advances one shared position through the listing every
+// interval and tells subscribers about each change. The position follows
+// the photo's name, so new or deleted photos don't make frames jump.
+type slideshow struct {
+	index    *photoIndex
+	interval time.Duration
+
+	mu        sync.Mutex
+	hash      string  // listing hash list was built from
+	list      []Photo // listing in slideshow order
+	name      string  // current photo
+	pos       int     // its position, for when it disappears
+	changedAt time.Time
+	subs      map[chan struct{}]struct{}
+}
+
+func newSlideshow(index *photoIndex, interval time.Duration) *slideshow {
+	return &slideshow{
+		index:     index,
+		interval:  interval,
+		changedAt: time.Now(),
+		subs:      make(map[chan struct{}]struct{}),
+	}
+}
+
+// run advances the slideshow until the process exits.
+func (s *slideshow) run() {
+	t := time.NewTicker(s.interval)
+	defer t.Stop()
+	for range t.C {
+		s.advance()
+	}
+}
+
+func (s *slideshow) advance() {
+	s.mu.Lock()
+	s.refresh()
+	if len(s.list) > 0 {
+		s.pos = (s.pos + 1) % len(s.list)
+		s.name = s.list[s.pos].Name
+	}
+	s.changedAt = time.Now()
+	for ch := range s.subs {
+		select {
+		case ch <- struct{}{}:
+		default:
+			// Already has a wakeup pending; it will read the latest state.
+		}
+	}
+	s.mu.Unlock()
+}
+
+// refresh rebuilds list when the index changed and re-finds the current
+// photo in it. Callers hold s.mu.
+func (s *slideshow) refresh() {
+	photos, hash, _, _ := s.index.snapshot()
+	if hash != s.hash || s.list == nil {
+		s.hash = hash
+		s.list = sortPhotos(append([]Photo(nil), photos...), "")
+	}
+	if len(s.list) == 0 {
+		s.name, s.pos = "", 0
+		return
+	}
+	for i, p := range s.list {
+		if p.Name == s.name {
+			s.pos = i
+			return
+		}
+	}
+	// Current photo is gone (or nothing was picked yet): whatever now sits
+	// in its slot takes over.
+	s.pos = min(s.pos, len(s.list)-1)
+	s.name = s.list[s.pos].Name
+}
+
+func (s *slideshow) state() SlideshowState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refresh()
+
+	st := SlideshowState{
+		Index:      s.pos,
+		Count:      len(s.list),
+		ChangedAt:  s.changedAt.UnixMilli(),
+		NextChange: s.changedAt.Add(s.interval).UnixMilli(),
+	}
+	if len(s.list) > 0 {
+		p := s.list[s.pos]
+		st.Photo = &p
+	}
+	return st
+}
+
+func (s *slideshow) subscribe() chan struct{} {
+	ch := make(chan struct{}, 1)
+	s.mu.Lock()
+	s.subs[ch] = struct{}{}
+	s.mu.Unlock()
+	return ch
+}
+
+func (s *slideshow) unsubscribe(ch chan struct{}) {
+	s.mu.Lock()
+	delete(s.subs, ch)
+	s.mu.Unlock()
+}
+
+// serveState answers GET /api/slideshow/state.
+func (s *slideshow) serveState(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, s.state())
+}
+
+// serveEvents streams a "state" event on connect and after every change,
+// until the client goes away.
+func (s *slideshow) serveEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ch := s.subscribe()
+	defer s.unsubscribe(ch)
+
+	rc := startSSE(w)
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+
+	send := func() error {
+		b, err := json.Marshal(s.state())
+		if err != nil {
+			return err
+		}
+		return writeSSE(rc, w, "state", b)
+	}
+	if send() != nil {
+		return
+	}
+	for {
+		var err error
+		select {
+		case <-ch:
+			err = send()
+		case <-heartbeat.C:
+			err = writeSSEPing(rc, w)
+		case <-r.Context().Done():
+			return
+		case <-shuttingDown:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ---- Server-Sent Events ----
+
+// sseHeartbeat is how often an idle stream gets a comment line, so proxies
+// don't close it and dead clients are noticed.
+const sseHeartbeat = 25 * time.Second
+
+// startSSE sends the event-stream headers. Streams outlive WRITE_TIMEOUT by
+// design, so the write deadline is lifted for this response.
+func startSSE(w http.ResponseWriter) *http.ResponseController {
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	// Tell nginx not to buffer the stream.
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	_ = rc.Flush()
+	return rc
+}
+
+// writeSSE sends one event; data must be a single line (e.g. compact JSON).
+func writeSSE(rc *http.ResponseController, w http.ResponseWriter, event string, data []byte) error {
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+		return err
+	}
+	return rc.Flush()
+}
+
+// writeSSEPing sends a comment line, which EventSource ignores.
+func writeSSEPing(rc *http.ResponseController, w http.ResponseWriter) error {
+	if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+		return err
+	}
+	return rc.Flush()
+}
@@ -1,39 +1,88 @@
 (() => {
   const imgA = document.getElementById("imgA");
   const imgB = document.getElementById("imgB");
+  const video = document.getElementById("video");
   const hud = document.getElementById("hud");
   const statusEl = document.getElementById("status");
+  const captionEl = document.getElementById("caption");
+  const stage = document.getElementById("stage");
 
-  // Query params (client-side only):
+  // Query params (client-side only; defaults come from the server's /api/config):
   //  - seconds=10
-  //  - shuffle=1
+  //  - shuffle=1 (default on, unless the server sets DEFAULT_ORDER)
   //  - fit=contain|cover
   //  - hud=1
-  //  - order=mtime_desc|mtime_asc|name_asc|name_desc
+  //  - order=mtime_desc|mtime_asc|name_asc|name_desc|exif_asc|exif_desc|random|shuffle_daily|weighted|smart|album_balanced
+  //    (default: the server's DEFAULT_ORDER, normally mtime_desc)
+  //  - album=<subfolder> (only show photos from that folder; needs RECURSIVE=true on the server)
+  //  - maxpixels=40000000 (skip photos larger than this many pixels, for low-memory devices)
   //  - refresh=60 (seconds to re-fetch list)
+  //  - watch=1 (get pushed list changes instead of re-fetching every `refresh` seconds; default on)
   //  - awake=1 (request Screen Wake Lock; default on)
+  //  - captions=1 (show the photo's caption, from a sidecar file, or its name)
+  //  - transition=fade|none
+  //  - sync=1 (show whatever the server's shared slideshow shows; needs SLIDESHOW_INTERVAL on the server)
+  //  - preset=<token> (from /api/preset; the server expands it into the params above before the page loads)
   const params = new URLSearchParams(location.search);
 
-  const seconds = clampInt(params.get("seconds"), 10, 1, 3600);
-  const shuffle = truthy(params.get("shuffle"), true);
+  let seconds = clampInt(params.get("seconds"), 10, 1, 3600);
+  let shuffle = truthy(params.get("shuffle"), true);
+  let showCaptions = truthy(params.get("captions"), false);
   const fit = (params.get("fit") || "contain").toLowerCase();
   const showHud = truthy(params.get("hud"), false);
-  const order = (params.get("order") || "mtime_desc");
+  const order = params.get("order") || "";
+  const album = params.get("album") || "";
+  const maxPixels = params.get("maxpixels") || "";
   const refreshSeconds = clampInt(params.get("refresh"), 60, 5, 3600);
   const keepAwake = truthy(params.get("awake"), true);
+  const watch = truthy(params.get("watch"), true);
+  const sync = truthy(params.get("sync"), false);
 
   imgA.style.objectFit = (fit === "cover") ? "cover" : "contain";
   imgB.style.objectFit = (fit === "cover") ? "cover" : "contain";
+  video.style.objectFit = (fit === "cover") ? "cover" : "contain";
 
   if (!showHud) hud.classList.add("hidden");
   else hud.classList.remove("hidden");
 
+  async function loadConfig() {
+    // Server-wide defaults; anything given in the URL wins.
+    try {
+      const res = await fetch("/api/config", { cache: "no-cache" });
+      if (!res.ok) return;
+      const cfg = await res.json();
+      if (!params.has("seconds") && cfg.slide_interval_ms) {
+        seconds = Math.max(1, Math.round(cfg.slide_interval_ms / 1000));
+      }
+      if (!params.has("shuffle") && cfg.default_order && cfg.default_order !== "mtime_desc") {
+        // The server picked an order; play it as is.
+        shuffle = false;
+      }
+      if (!params.has("captions")) showCaptions = !!cfg.show_captions;
+      if (!params.has("transition") && cfg.transition === "none") stage.classList.add("no-transition");
+    } catch {
+      // Built-in defaults.
+    }
+    if (params.get("transition") === "none") stage.classList.add("no-transition");
+  }
+
+  function setCaption(photo) {
+    if (!showCaptions || !photo || !photo.name) {
+      captionEl.classList.add("hidden");
+      return;
+    }
+    const base = photo.name.split("/").pop();
+    captionEl.textContent = photo.caption || base.replace(/\.[^.]+$/, "");
+    captionEl.classList.remove("hidden");
+  }
+
   let photos = [];
   let idx = 0;
   let paused = false;
   let active = "A";
   let timer = null;
   let lastListHash = "";
+  let shownUrl = "";
 
   // ---- Wake Lock (best-effort; OS/browser may still dim/sleep) ----
   let wakeLock = null;
@@ -124,6 +173,25 @@
     active = (active === "A") ? "B" : "A";
   }
 
+  // A clip is playing; the timer waits for it to end.
+  function playingVideo() {
+    return video.classList.contains("visible") && !video.ended;
+  }
+
+  function hideVideo() {
+    video.classList.remove("visible");
+    video.pause();
+  }
+
+  function preloadVideo(url) {
+    return new Promise((resolve) => {
+      video.oncanplay = () => resolve(true);
+      video.onerror = () => resolve(false);
+      video.src = url;
+      video.load();
+    });
+  }
+
   function preload(url) {
     return new Promise((resolve) => {
       const i = new Image();
@@ -141,6 +209,17 @@
 
     setStatus(`${idx + 1}/${photos.length} • ${paused ? "paused" : seconds + "s"} • ${shuffle ? "shuffle" : "ordered"} • fit=${fit}`);
 
+    await showUrl(url, immediate, photos[idx].kind);
+    setCaption(photos[idx]);
+  }
+
+  async function showUrl(url, immediate = false, kind = "image") {
+    shownUrl = url;
+    if (kind === "video") {
+      await showVideo(url, immediate);
+      return;
+    }
+
     const nxt = nextImg();
     // preload first to minimize blank flashes
     await preload(url);
@@ -151,6 +230,7 @@
       // Make next visible instantly without animation
       imgA.classList.remove("visible");
       imgB.classList.remove("visible");
+      hideVideo();
       nxt.classList.add("visible");
       active = (nxt === imgA) ? "A" : "B";
       return;
@@ -158,18 +238,43 @@
 
     // Crossfade
     requestAnimationFrame(() => {
+      hideVideo();
       swapLayers();
     });
   }
 
+  // Clips play muted (autoplay needs that) over the still layers, once
+  // through; see startTimer for moving on afterwards.
+  async function showVideo(url, immediate) {
+    await preloadVideo(url);
+    video.currentTime = 0;
+    if (!paused) video.play().catch(() => {});
+
+    const show = () => {
+      imgA.classList.remove("visible");
+      imgB.classList.remove("visible");
+      video.classList.add("visible");
+    };
+    if (immediate) show();
+    else requestAnimationFrame(show);
+  }
+
   function startTimer() {
     stopTimer();
     timer = setInterval(() => {
-      if (paused) return;
+      if (paused || playingVideo()) return;
       showAt(nextIndex());
     }, seconds * 1000);
   }
 
+  // A clip longer than the interval moves on as soon as it ends, and the
+  // next photo gets its full time.
+  video.addEventListener("ended", () => {
+    if (paused || sync || !timer) return;
+    showAt(nextIndex());
+    startTimer();
+  });
+
   function stopTimer() {
     if (timer) clearInterval(timer);
     timer = null;
@@ -177,9 +282,11 @@
 
   async function fetchPhotos() {
     const url = new URL("/api/photos", location.origin);
-    url.searchParams.set("order", order);
+    if (order) url.searchParams.set("order", order);
+    if (album) url.searchParams.set("album", album);
+    if (maxPixels) url.searchParams.set("maxpixels", maxPixels);
 
-    const res = await fetch(url.toString(), { cache: "no-store" });
+    const res = await fetch(url.toString(), { cache: "no-cache" });
     if (!res.ok) throw new Error(`api returned ${res.status}`);
     const data = await res.json();
     const list = data.photos || [];
@@ -191,37 +298,102 @@
     lastListHash = signature;
   }
 
-  async function refreshListPeriodically() {
-    setInterval(async () => {
+  async function reloadList() {
+    try {
+      const url = new URL("/api/photos", location.origin);
+      if (order) url.searchParams.set("order", order);
+      if (album) url.searchParams.set("album", album);
+      if (maxPixels) url.searchParams.set("maxpixels", maxPixels);
+      // no-cache revalidates with If-None-Match, so unchanged lists cost a 304.
+      const res = await fetch(url.toString(), { cache: "no-cache" });
+      if (!res.ok) return;
+      const data = await res.json();
+      await applyList(data.photos || []);
+    } catch {
+      // ignore
+    }
+  }
+
+  function refreshListPeriodically() {
+    setInterval(reloadList, refreshSeconds * 1000);
+  }
+
+  function listenForChanges() {
+    // The server pushes "photos-changed" on connect and on every change;
+    // EventSource reconnects by itself, and the connect event catches up
+    // on anything missed meanwhile.
+    const events = new EventSource("/api/events");
+    events.addEventListener("photos-changed", reloadList);
+  }
+
+  async function applyList(list) {
+    const signature = JSON.stringify(list.map(p => [p.name, p.mtime]));
+    if (signature === lastListHash) return;
+
+    photos = list;
+    lastListHash = signature;
+
+    // If current index is out of range after deletions, clamp.
+    if (idx >= photos.length) idx = 0;
+    // Continue slideshow seamlessly; show current immediately.
+    await showAt(idx, true);
+  }
+
+  async function watchForChanges() {
+    // The server holds each request until the listing changes (or ~30s pass),
+    // so new photos show up within a second or so.
+    let hash = "";
+    for (;;) {
       try {
-        const url = new URL("/api/photos", location.origin);
-        url.searchParams.set("order", order);
+        const url = new URL("/api/photos/watch", location.origin);
+        if (order) url.searchParams.set("order", order);
+        if (album) url.searchParams.set("album", album);
+        if (maxPixels) url.searchParams.set("maxpixels", maxPixels);
+        if (hash) url.searchParams.set("hash", hash);
         const res = await fetch(url.toString(), { cache: "no-store" });
-        if (!res.ok) return;
+        if (!res.ok) throw new Error(`api returned ${res.status}`);
         const data = await res.json();
-        const list = data.photos || [];
-        const signature = JSON.stringify(list.map(p => [p.name, p.mtime]));
-
-        if (signature !== lastListHash) {
-          photos = list;
-          lastListHash = signature;
-
-          // If current index is out of range after deletions, clamp.
-          if (idx >= photos.length) idx = 0;
-          // Continue slideshow seamlessly; show current immediately.
-          await showAt(idx, true);
-        }
+        if (!data.hash) throw new Error("server could not scan photos");
+        hash = data.hash;
+        await applyList(data.photos || []);
       } catch {
-        // ignore
+        // Back off before retrying so an outage doesn't become a busy loop.
+        await new Promise((resolve) => setTimeout(resolve, refreshSeconds * 1000));
+      }
+    }
+  }
+
+  function followServer() {
+    // The server picks the photo and pushes every change; EventSource
+    // reconnects by itself after network hiccups.
+    let first = true;
+    const events = new EventSource("/api/slideshow/events");
+    events.addEventListener("state", async (e) => {
+      const state = JSON.parse(e.data);
+      if (!state.photo) {
+        setStatus("No photos found in /photos (mount your directory).");
+        hud.classList.remove("hidden");
+        return;
       }
-    }, refreshSeconds * 1000);
+      setStatus(`${state.index + 1}/${state.count} • synced • fit=${fit}`);
+      await showUrl(state.photo.url, first, state.photo.kind);
+      setCaption(state.photo);
+      first = false;
+    });
+    events.onerror = () => setStatus("Reconnecting to the server…");
   }
 
   function bindKeys() {
     window.addEventListener("keydown", async (e) => {
+      if (sync && (e.key === " " || e.key.startsWith("Arrow"))) {
+        // The server drives a synced slideshow.
+        return;
+      }
       if (e.key === " " || e.code === "Space") {
         e.preventDefault();
         paused = !paused;
+        if (paused) video.pause();
+        else if (playingVideo()) video.play().catch(() => {});
         setStatus(`${idx + 1}/${photos.length} • ${paused ? "paused" : seconds + "s"} • ${shuffle ? "shuffle" : "ordered"} • fit=${fit}`);
         return;
       }
@@ -249,15 +421,39 @@
         hud.classList.toggle("hidden");
         return;
       }
+      if (e.key.toLowerCase() === "d" && shownUrl) {
+        e.preventDefault();
+        downloadShown();
+        return;
+      }
     });
   }
 
+  // Saves the original of the photo on screen; the server sends it as an
+  // attachment for ?download=1.
+  function downloadShown() {
+    const u = new URL(shownUrl, location.href);
+    u.searchParams.set("download", "1");
+    const a = document.createElement("a");
+    a.href = u.toString();
+    document.body.appendChild(a);
+    a.click();
+    a.remove();
+  }
+
   async function boot() {
     bindKeys();
 
     // Best-effort attempt to keep screen awake while visible.
     // Note: Some platforms require user interaction or may ignore due to power settings.
     requestWakeLock();
+    await loadConfig();
+
+    if (sync) {
+      setStatus("Connecting…");
+      followServer();
+      return;
+    }
 
     try {
       setStatus("Loading photos…");
@@ -274,12 +470,19 @@
       await showAt(idx, true);
 
       startTimer();
-      refreshListPeriodically();
+      if (!watch) refreshListPeriodically();
+      else if ("EventSource" in window) listenForChanges();
+      else watchForChanges();
     } catch (err) {
       setStatus(`Error: ${err.message}`);
       hud.classList.remove("hidden");
     }
   }
 
+  // Installable as an app (see /manifest.json); the worker caches the shell.
+  if ("serviceWorker" in navigator) {
+    navigator.serviceWorker.register("/sw.js").catch(() => {});
+  }
+
   boot();
 })();
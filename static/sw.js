@@ -0,0 +1,43 @@
+// Frameserve service worker: keeps the slideshow shell (page, script,
+// styles, icon) so an installed frame still starts while the server is
+// briefly unreachable. Photos and the API always go to the network; the
+// browser's HTTP cache already keeps photos.
+const CACHE = "frameserve-shell-v1";
+const SHELL = ["/", "/static/app.js", "/static/styles.css", "/static/camera.svg"];
+
+self.addEventListener("install", (event) => {
+  event.waitUntil(
+    caches.open(CACHE)
+      // Without a login cookie yet the shell can't be fetched; it gets cached
+      // on the next successful load instead.
+      .then((cache) => cache.addAll(SHELL).catch(() => {}))
+      .then(() => self.skipWaiting())
+  );
+});
+
+self.addEventListener("activate", (event) => {
+  event.waitUntil(
+    caches.keys()
+      .then((keys) => Promise.all(keys.filter((k) => k !== CACHE).map((k) => caches.delete(k))))
+      .then(() => self.clients.claim())
+  );
+});
+
+// Network first, so updates show up right away; the cache is only a fallback.
+self.addEventListener("fetch", (event) => {
+  const url = new URL(event.request.url);
+  if (event.request.method !== "GET" || url.origin !== self.location.origin || !SHELL.includes(url.pathname)) {
+    return;
+  }
+  event.respondWith(
+    fetch(event.request)
+      .then((resp) => {
+        if (resp.ok) {
+          const copy = resp.clone();
+          caches.open(CACHE).then((cache) => cache.put(url.pathname, copy));
+        }
+        return resp;
+      })
+      .catch(() => caches.match(url.pathname).then((cached) => cached || Response.error()))
+  );
+});
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// ---- Photo storage (STORAGE=local|s3) ----
+
+// photoStore is where the photos live. Names are slash-separated paths
+// relative to the store's root, as used in /photos/ URLs. Stat and Open
+// return fs.ErrInvalid for names that can't be photos (bad path, extension)
+// and fs.ErrNotExist for photos that aren't there.
+type photoStore interface {
+	// List calls fn for every servable photo, in no particular order. An
+	// error from fn stops the listing and is returned. Photos that can't be
+	// read are left out and reported to errs (nil to ignore them), so one
+	// bad file doesn't fail the listing.
+	List(fn func(Photo) error, errs *scanErrors) error
+	// Stat and Open give up when ctx ends; for Open that includes reads
+	// from the returned file.
+	Stat(ctx context.Context, name string) (fs.FileInfo, error)
+	Open(ctx context.Context, name string) (io.ReadSeekCloser, fs.FileInfo, error)
+}
+
+// localStore is PHOTOS_DIR on disk, the default. Features that write to
+// the folder or read whole files on every scan (upload, delete, rotate,
+// blurhash, dedup, frameserve.json weights) only work with it.
+type localStore struct {
+	dir       string
+	recursive bool
+}
+
+func (s *localStore) List(fn func(Photo) error, errs *scanErrors) error {
+	return walkPhotos(s.dir, s.recursive, fn, errs)
+}
+
+func (s *localStore) Stat(_ context.Context, name string) (fs.FileInfo, error) {
+	if _, ok := photoPath(s.dir, s.recursive, name); !ok {
+		return nil, fs.ErrInvalid
+	}
+	_, fi, ok := lookupPhoto(s.dir, s.recursive, name)
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return fi, nil
+}
+
+func (s *localStore) Open(_ context.Context, name string) (io.ReadSeekCloser, fs.FileInfo, error) {
+	if _, ok := photoPath(s.dir, s.recursive, name); !ok {
+		return nil, nil, fs.ErrInvalid
+	}
+	fullPath, _, ok := lookupPhoto(s.dir, s.recursive, name)
+	if !ok {
+		return nil, nil, fs.ErrNotExist
+	}
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, fi, nil
+}
+
+// storeWeights loads frameserve.json weights, which only local folders have.
+func storeWeights(store photoStore) map[string]int {
+	if ls, ok := store.(*localStore); ok {
+		return loadWeights(ls.dir)
+	}
+	return nil
+}
+
+// statStored is store.Stat for handlers: it answers 404 for missing photos
+// and 502 when the store itself fails, reporting whether to carry on.
+func statStored(w http.ResponseWriter, r *http.Request, store photoStore, name string) (fs.FileInfo, bool) {
+	fi, err := store.Stat(r.Context(), name)
+	switch {
+	case errors.Is(err, fs.ErrNotExist), errors.Is(err, fs.ErrInvalid):
+		http.NotFound(w, r)
+		return nil, false
+	case err != nil:
+		logRequestf(r, "storage error: %s: %v", name, err)
+		http.Error(w, "failed to reach photo storage", http.StatusBadGateway)
+		return nil, false
+	}
+	return fi, true
+}
+
+// serveStored serves a photo from a non-local store as-is: no EXIF
+// auto-orientation (that needs the file's header on disk) and no
+// transcoding, since such formats are left out of the listing. With
+// download, it comes as an attachment (?download=1).
+func (c *imageCache) serveStored(w http.ResponseWriter, r *http.Request, store photoStore, name string, download bool) {
+	f, fi, err := store.Open(r.Context(), name)
+	if err != nil {
+		c.storeError(w, r, name, err)
+		return
+	}
+	defer f.Close()
+
+	if target, stale := versionRedirect(r.URL, fi.ModTime().Unix()); stale {
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, r, target, http.StatusFound)
+		return
+	}
+
+	if download {
+		w.Header().Set("Content-Disposition", attachmentDisposition(path.Base(name)))
+	}
+	if ct := mime.TypeByExtension(strings.ToLower(path.Ext(name))); ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+	w.Header().Set("ETag", imageETag(fi, ""))
+	http.ServeContent(rangeGuard(w, fi.Size()), r, "", fi.ModTime(), f)
+}
+
+// serveStoredThumb is serveThumb for a non-local store. Thumbnails are cached
+// in THUMB_CACHE_DIR like local ones, so each is only downloaded once.
+func (c *imageCache) serveStoredThumb(w http.ResponseWriter, r *http.Request, store photoStore, name string, width int) {
+	if isVideo(name) {
+		c.serveStored(w, r, store, name, false)
+		return
+	}
+	fi, err := store.Stat(r.Context(), name)
+	if err != nil {
+		c.storeError(w, r, name, err)
+		return
+	}
+	// The thumbnail is finished for the cache even if the client leaves.
+	genCtx := context.WithoutCancel(r.Context())
+	err = c.serveDerived(w, r, name, fi, fmt.Sprintf("w%d", width), func() ([]byte, error) {
+		f, _, err := store.Open(genCtx, name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return makeThumb(f, width, 0)
+	})
+	if err == nil {
+		return
+	}
+	if !errors.Is(err, errThumbNotNeeded) && !errors.Is(err, image.ErrFormat) {
+		logRequestf(r, "thumbnail error: %s: %v", name, err)
+	}
+	c.serveStored(w, r, store, name, false)
+}
+
+// storeError answers a failed Stat or Open of a photo to be served.
+func (c *imageCache) storeError(w http.ResponseWriter, r *http.Request, name string, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		c.missingPhoto(w, r, true)
+	case errors.Is(err, fs.ErrInvalid):
+		c.missingPhoto(w, r, false)
+	default:
+		logRequestf(r, "storage error: %s: %v", name, err)
+		http.Error(w, "failed to reach photo storage", http.StatusBadGateway)
+	}
+}
+
+// storeAlbumOrder is albumOrder for local folders; other stores have no
+// per-album orders.
+func storeAlbumOrder(store photoStore, album string) string {
+	if ls, ok := store.(*localStore); ok && ls.recursive {
+		return albumOrder(ls.dir, album)
+	}
+	return ""
+}
+
+// storeAlbumPhotos is albumPhotos for any store. Remote stores have no
+// directories, so there an album exists if any photo lives under it.
+func storeAlbumPhotos(store photoStore, album string, photos []Photo) ([]Photo, bool) {
+	if ls, ok := store.(*localStore); ok {
+		return albumPhotos(ls.dir, ls.recursive, album, photos)
+	}
+	album = strings.Trim(album, "/")
+	if album == "" || strings.Contains(album, `\`) || path.Clean("/"+album) != "/"+album {
+		return nil, false
+	}
+	prefix := album + "/"
+
+	filtered := photos[:0]
+	for _, p := range photos {
+		if strings.HasPrefix(p.Name, prefix) {
+			filtered = append(filtered, p)
+		}
+	}
+	return filtered, len(filtered) > 0
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// ---- Streaming listing (/api/photos?stream=true) ----
+
+// streamBatch is how many photos are encoded between flushes.
+const streamBatch = 256
+
+// streamUnsupported are the /api/photos params that need the whole listing
+// in memory, so they can't be combined with ?stream=true.
+var streamUnsupported = []string{"order", "limit", "offset", "dedup", "album", "since", "maxpixels"}
+
+// streamConflict returns the first param in q that stream mode can't honor,
+// or "".
+func streamConflict(q url.Values) string {
+	for _, k := range streamUnsupported {
+		if q.Has(k) {
+			return k
+		}
+	}
+	return ""
+}
+
+// streamPhotos writes the same shape as /api/photos ({"photos":[...],
+// "count":N}) straight from the store's listing, one batch at a time, so memory
+// stays flat however big the folder is. The price is that photos come in
+// listing order, unsorted, and there is no ETag. If the walk fails midway
+// the JSON is left unterminated so clients can't mistake it for a full list.
+func streamPhotos(w http.ResponseWriter, r *http.Request, store photoStore, favorites *favoriteStore, fields map[string]bool) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	weights := storeWeights(store)
+	enc := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+
+	io.WriteString(w, `{"photos":[`)
+	count := 0
+	batch := make([]Photo, 0, streamBatch)
+	writeBatch := func() error {
+		applyWeights(weights, batch)
+		markFavorites(favorites, batch)
+		for _, p := range batch {
+			if count > 0 {
+				io.WriteString(w, ",")
+			}
+			if err := enc.Encode(projectPhoto(p, fields)); err != nil {
+				return err
+			}
+			count++
+		}
+		batch = batch[:0]
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	}
+
+	err := store.List(func(p Photo) error {
+		batch = append(batch, p)
+		if len(batch) < streamBatch {
+			return nil
+		}
+		return writeBatch()
+	}, nil)
+	if err == nil {
+		err = writeBatch()
+	}
+	if err != nil {
+		logRequestf(r, "stream error: %v", err)
+		return
+	}
+
+	fmt.Fprintf(w, "],\"count\":%d}\n", count)
+}
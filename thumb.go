@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	// Decoders for the allowed source formats.
+	_ "image/gif"
+	_ "image/png"
+
+	_ "golang.org/x/image/webp"
+
+	"golang.org/x/image/draw"
+)
+
+// ---- Derived images (thumbnails, transcodes) ----
+
+const maxThumbWidth = 4096
+
+// thumbQuality (THUMB_QUALITY) is the JPEG quality of thumbnails and
+// transcodes.
+var thumbQuality = 82
+
+// imageCache produces JPEG variants of photos and keeps them in cache (on
+// disk by default) so each variant is only generated once per photo version.
+type imageCache struct {
+	cache derivedCache
+	// autoOrient applies the EXIF Orientation tag to served pixels.
+	autoOrient bool
+	// timeout bounds how long a request waits for a variant to be generated
+	// (0 = forever).
+	timeout time.Duration
+	// fallback replaces 404s for missing photos (FALLBACK_IMAGE); nil = off.
+	fallback *fallbackImage
+	// slots bounds how many variants are generated at once
+	// (TRANSCODE_CONCURRENCY); a request waits at most queueTimeout for one.
+	slots        chan struct{}
+	queueTimeout time.Duration
+}
+
+// serveOriginal serves the photo itself, transcoding formats that browsers
+// can't display.
+//
+// Every path here ends in http.ServeFile or http.ServeContent, which answer
+// Range requests (206 + Content-Range) and conditional GETs for us; keep it
+// that way rather than copying bytes to w directly. Derived images carry the
+// photo's mtime as Last-Modified too, and every path sets an imageETag and
+// wraps w in rangeGuard.
+func (c *imageCache) serveOriginal(w http.ResponseWriter, r *http.Request, name, fullPath string, fi os.FileInfo) {
+	if needsTranscode(name) {
+		c.serveTranscoded(w, r, name, fullPath, fi)
+		return
+	}
+
+	if o := c.orientation(name, fullPath, fi); o > 1 {
+		c.serveOriented(w, r, name, fullPath, fi, o)
+		return
+	}
+
+	ext := strings.ToLower(filepath.Ext(name))
+	if mediaType, ok := negotiatedExts[ext]; ok {
+		w.Header().Add("Vary", "Accept")
+		_, canDecode := transcodeDecoders[ext]
+		if canDecode && !acceptsMediaType(r.Header.Get("Accept"), mediaType) {
+			c.serveTranscoded(w, r, name, fullPath, fi)
+			return
+		}
+		// Otherwise serve the original and hope for the best.
+	}
+
+	// Content-Type best effort based on extension
+	if ct := mime.TypeByExtension(ext); ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+	w.Header().Set("ETag", imageETag(fi, ""))
+	http.ServeFile(rangeGuard(w, fi.Size()), r, fullPath)
+}
+
+// serveThumb writes a JPEG of the photo scaled down to at most width pixels
+// wide. Photos that are already narrow enough (or can't be decoded), video
+// clips and animated WebPs (see makeThumb) are served as-is.
+func (c *imageCache) serveThumb(w http.ResponseWriter, r *http.Request, name, fullPath string, fi os.FileInfo, width int) {
+	if isVideo(name) {
+		c.serveOriginal(w, r, name, fullPath, fi)
+		return
+	}
+	variant := fmt.Sprintf("w%d", width)
+	if c.autoOrient {
+		// Keep oriented and unoriented thumbnails apart so toggling
+		// AUTO_ORIENT never serves stale ones.
+		variant += "-o"
+	}
+	orientation := c.orientation(name, fullPath, fi)
+	err := c.serveDerived(w, r, name, fi, variant, func() ([]byte, error) {
+		f, err := os.Open(fullPath)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return makeThumb(f, width, orientation)
+	})
+	if err == nil {
+		return
+	}
+	if !errors.Is(err, errThumbNotNeeded) && !errors.Is(err, image.ErrFormat) {
+		logRequestf(r, "thumbnail error: %s: %v", name, err)
+	}
+	c.serveOriginal(w, r, name, fullPath, fi)
+}
+
+// serveTranscoded converts a photo the client can't render into a full-size
+// JPEG, or answers 415 when this build has no decoder for it.
+func (c *imageCache) serveTranscoded(w http.ResponseWriter, r *http.Request, name, fullPath string, fi os.FileInfo) {
+	ext := strings.ToLower(filepath.Ext(name))
+	decode, ok := transcodeDecoders[ext]
+	if !ok {
+		w.Header().Set("Cache-Control", "no-store")
+		http.Error(w, "this server was built without a decoder for "+ext+" images", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	err := c.serveDerived(w, r, name, fi, "jpeg", func() ([]byte, error) {
+		f, err := os.Open(fullPath)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		img, err := decode(f)
+		if err != nil {
+			return nil, err
+		}
+		return encodeJPEG(img)
+	})
+	if err != nil {
+		logRequestf(r, "transcode error: %s: %v", name, err)
+		w.Header().Set("Cache-Control", "no-store")
+		http.Error(w, "failed to transcode image", http.StatusInternalServerError)
+	}
+}
+
+// orientation is the EXIF Orientation to apply to name, or 0 when
+// AUTO_ORIENT is off or the photo doesn't carry one.
+func (c *imageCache) orientation(name, fullPath string, fi os.FileInfo) int {
+	if !c.autoOrient || !hasExif(name) {
+		return 0
+	}
+	return photoMetas.get(fullPath, fi.ModTime().Unix()).Orientation
+}
+
+// serveOriented re-encodes a JPEG upright according to its EXIF Orientation.
+// If that fails the original is served unchanged.
+func (c *imageCache) serveOriented(w http.ResponseWriter, r *http.Request, name, fullPath string, fi os.FileInfo, orientation int) {
+	err := c.serveDerived(w, r, name, fi, "oriented", func() ([]byte, error) {
+		f, err := os.Open(fullPath)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		img, _, err := image.Decode(f)
+		if err != nil {
+			return nil, err
+		}
+		return encodeJPEG(applyOrientation(img, orientation))
+	})
+	if err != nil {
+		logRequestf(r, "orientation error: %s: %v", name, err)
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("ETag", imageETag(fi, ""))
+		http.ServeFile(rangeGuard(w, fi.Size()), r, fullPath)
+	}
+}
+
+// serveDerived serves the cached variant of a photo, producing it with gen on
+// a miss. Nothing is written to w when gen fails, except when it takes longer
+// than c.timeout: then the client gets a 504 (and nil is returned) while gen
+// finishes in the background and still fills the cache. Likewise a request
+// that can't get a processing slot within c.queueTimeout gets a 503.
+func (c *imageCache) serveDerived(w http.ResponseWriter, r *http.Request, name string, fi os.FileInfo, variant string, gen func() ([]byte, error)) error {
+	key := derivedCacheKey(name, fi.ModTime().Unix(), variant)
+
+	if f, size, ok := c.cache.open(key); ok {
+		defer f.Close()
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("ETag", imageETag(fi, variant))
+		// The photo's mtime rather than the cache file's, so it matches
+		// what a fresh variant is sent with.
+		http.ServeContent(rangeGuard(w, size), r, "", fi.ModTime(), f)
+		return nil
+	}
+
+	if !c.acquire(w, r, name, variant) {
+		return nil
+	}
+
+	type result struct {
+		b   []byte
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		defer c.release()
+		b, err := gen()
+		if err == nil {
+			if err := c.cache.put(key, b); err != nil {
+				log.Printf("image cache write failed: %v", err)
+			}
+		}
+		done <- result{b, err}
+	}()
+
+	var timeout <-chan time.Time
+	if c.timeout > 0 {
+		t := time.NewTimer(c.timeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	var b []byte
+	select {
+	case res := <-done:
+		if res.err != nil {
+			return res.err
+		}
+		b = res.b
+	case <-timeout:
+		// A corrupt file or a stalled disk; don't hold the connection.
+		logRequestf(r, "image timeout: %s (%s) took longer than %s", name, variant, c.timeout)
+		w.Header().Set("Cache-Control", "no-store")
+		http.Error(w, "timed out reading image", http.StatusGatewayTimeout)
+		return nil
+	case <-r.Context().Done():
+		// Client gone; nobody to answer.
+		return nil
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("ETag", imageETag(fi, variant))
+	http.ServeContent(rangeGuard(w, int64(len(b))), r, "", fi.ModTime(), bytes.NewReader(b))
+	return nil
+}
+
+// imageETag identifies what is served for one version of a photo: its
+// mtime and size, plus the variant (and quality) for derived images, or ""
+// for the file itself. Set before http.ServeContent/ServeFile, it lets them
+// answer If-None-Match as well as If-Modified-Since with a 304.
+func imageETag(fi os.FileInfo, variant string) string {
+	if variant == "" {
+		return fmt.Sprintf(`"%x-%x"`, fi.ModTime().Unix(), fi.Size())
+	}
+	return fmt.Sprintf(`"%x-%x-%s-q%d"`, fi.ModTime().Unix(), fi.Size(), variant, thumbQuality)
+}
+
+// rangeGuard wraps w for http.ServeContent/ServeFile so that a 416 (a Range
+// the image can't satisfy) always says why: Go only adds Content-Range:
+// bytes */size when the range starts past the end, not for malformed ones.
+// It also keeps the image's long-lived Cache-Control, ETag and
+// Last-Modified off the error, so caches don't hold on to it. size is that
+// of the bytes being served, or -1 if unknown.
+func rangeGuard(w http.ResponseWriter, size int64) http.ResponseWriter {
+	return &rangeGuardWriter{ResponseWriter: w, size: size}
+}
+
+type rangeGuardWriter struct {
+	http.ResponseWriter
+	size int64
+}
+
+func (g *rangeGuardWriter) WriteHeader(code int) {
+	if code == http.StatusRequestedRangeNotSatisfiable {
+		h := g.Header()
+		if h.Get("Content-Range") == "" && g.size >= 0 {
+			h.Set("Content-Range", fmt.Sprintf("bytes */%d", g.size))
+		}
+		h.Set("Cache-Control", "no-store")
+		h.Del("ETag")
+		h.Del("Last-Modified")
+	}
+	g.ResponseWriter.WriteHeader(code)
+}
+
+func (g *rangeGuardWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+// acquire takes a processing slot, waiting in line if all are busy. When it
+// reports false the request has been answered (503) or abandoned.
+func (c *imageCache) acquire(w http.ResponseWriter, r *http.Request, name, variant string) bool {
+	if c.slots == nil {
+		return true
+	}
+	select {
+	case c.slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	var timeout <-chan time.Time
+	if c.queueTimeout > 0 {
+		t := time.NewTimer(c.queueTimeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case c.slots <- struct{}{}:
+		return true
+	case <-timeout:
+		logRequestf(r, "image queue full: %s (%s) waited %s", name, variant, c.queueTimeout)
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "too many images being processed; try again", http.StatusServiceUnavailable)
+		return false
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+func (c *imageCache) release() {
+	if c.slots != nil {
+		<-c.slots
+	}
+}
+
+var errThumbNotNeeded = errors.New("image already within requested width")
+
+// makeThumb scales the photo in f down to width pixels wide, first turning
+// it upright per orientation (an EXIF Orientation value; 0 or 1 for none).
+func makeThumb(f io.ReadSeeker, width, orientation int) ([]byte, error) {
+	// Scaling would keep only the first frame.
+	if webpAnimated(f) {
+		return nil, errThumbNotNeeded
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return nil, err
+	}
+	displayWidth := cfg.Width
+	if orientation >= 5 {
+		displayWidth = cfg.Height
+	}
+	if displayWidth <= width {
+		return nil, errThumbNotNeeded
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	src, _, err := image.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+	src = applyOrientation(src, orientation)
+
+	b := src.Bounds()
+	height := b.Dy() * width / b.Dx()
+	if height < 1 {
+		height = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.BiLinear.Scale(dst, dst.Bounds(), src, b, draw.Src, nil)
+
+	return encodeJPEG(dst)
+}
+
+func encodeJPEG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: thumbQuality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// derivedCacheKey derives a flat, filesystem-safe cache file name. The mtime
+// is part of the key so edited photos get fresh variants, and the quality
+// so changing THUMB_QUALITY does too.
+func derivedCacheKey(name string, mtime int64, variant string) string {
+	sum := sha256.Sum256([]byte(name))
+	return fmt.Sprintf("%s-%d-%s-q%d.jpg", hex.EncodeToString(sum[:]), mtime, variant, thumbQuality)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newPhotoServer serves dir the way /photos/ does for a local folder, with
+// images (or, if nil, defaults with an in-memory cache).
+func newPhotoServer(t *testing.T, dir string, images *imageCache) *httptest.Server {
+	t.Helper()
+	if images == nil {
+		images = &imageCache{cache: newMemoryCache(1 << 20)}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/photos/", func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/photos/")
+		fullPath, fi, ok := lookupPhoto(dir, true, name)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		images.serveOriginal(w, r, name, fullPath, fi)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// writeTestJPEG writes a noisy JPEG (so it doesn't compress to almost
+// nothing) to dir/name and returns its size.
+func writeTestJPEG(t *testing.T, dir, name string, w, h int) int64 {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 37), uint8(y * 91), uint8(x * y), 255})
+		}
+	}
+	p := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := jpeg.Encode(f, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fi.Size()
+}
+
+func get(t *testing.T, url string, header map[string]string) (*http.Response, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res, body
+}
+
+func TestServeOriginalRange(t *testing.T) {
+	dir := t.TempDir()
+	size := writeTestJPEG(t, dir, "a.jpg", 64, 64)
+	if size <= 1024 {
+		t.Fatalf("test image is only %d bytes", size)
+	}
+	srv := newPhotoServer(t, dir, nil)
+
+	tests := []struct {
+		name         string
+		rangeHeader  string
+		wantStatus   int
+		wantRange    string
+		wantBodySize int64
+	}{
+		{"no range", "", http.StatusOK, "", size},
+		{"first KiB", "bytes=0-1023", http.StatusPartialContent, fmt.Sprintf("bytes 0-1023/%d", size), 1024},
+		{"suffix", "bytes=-100", http.StatusPartialContent, fmt.Sprintf("bytes %d-%d/%d", size-100, size-1, size), 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := map[string]string{}
+			if tt.rangeHeader != "" {
+				header["Range"] = tt.rangeHeader
+			}
+			res, body := get(t, srv.URL+"/photos/a.jpg", header)
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", res.StatusCode, tt.wantStatus)
+			}
+			if got := res.Header.Get("Content-Range"); got != tt.wantRange {
+				t.Errorf("Content-Range = %q, want %q", got, tt.wantRange)
+			}
+			if int64(len(body)) != tt.wantBodySize {
+				t.Errorf("body is %d bytes, want %d", len(body), tt.wantBodySize)
+			}
+		})
+	}
+}
+
+func TestUnsatisfiableRange(t *testing.T) {
+	fakeHEICDecoder(t)
+	dir := t.TempDir()
+	writeTestJPEG(t, dir, "a.jpg", 64, 64)
+	writeTestJPEG(t, dir, "x.heic", 64, 64)
+	srv := newPhotoServer(t, dir, nil)
+
+	for _, name := range []string{"a.jpg", "x.heic"} {
+		url := srv.URL + "/photos/" + name
+		// The size of what's served: the file, or the transcoded JPEG.
+		res, body := get(t, url, nil)
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%s: status = %d", name, res.StatusCode)
+		}
+		size := len(body)
+
+		for _, rangeHeader := range []string{
+			fmt.Sprintf("bytes=%d-", size),
+			fmt.Sprintf("bytes=%d-%d", size+100, size+200),
+			"bytes=oops",
+		} {
+			t.Run(name+" "+rangeHeader, func(t *testing.T) {
+				res, _ := get(t, url, map[string]string{"Range": rangeHeader})
+				if res.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+					t.Fatalf("status = %d, want 416", res.StatusCode)
+				}
+				if got, want := res.Header.Get("Content-Range"), fmt.Sprintf("bytes */%d", size); got != want {
+					t.Errorf("Content-Range = %q, want %q", got, want)
+				}
+				if got := res.Header.Get("Cache-Control"); got != "no-store" {
+					t.Errorf("Cache-Control = %q, want no-store", got)
+				}
+				if got := res.Header.Get("ETag"); got != "" {
+					t.Errorf("416 carries ETag %s", got)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ---- Derived image cache (THUMB_CACHE=disk|memory|none) ----
+
+// derivedCache keeps generated thumbnails and transcodes, keyed by
+// derivedCacheKey. A failed put only costs a regeneration later.
+type derivedCache interface {
+	// open returns a cached variant and its size, or false on a miss.
+	open(key string) (io.ReadSeekCloser, int64, bool)
+	put(key string, b []byte) error
+}
+
+// newDerivedCache builds the cache THUMB_CACHE asks for.
+func newDerivedCache(kind, dir string, maxBytes int64) (derivedCache, error) {
+	switch kind {
+	case "disk":
+		return diskCache{dir: dir}, nil
+	case "memory":
+		return newMemoryCache(maxBytes), nil
+	case "none":
+		return noCache{}, nil
+	}
+	return nil, fmt.Errorf("want disk, memory or none, not %q", kind)
+}
+
+// diskCache keeps variants as files in THUMB_CACHE_DIR, the default. They
+// survive restarts and are never evicted.
+type diskCache struct {
+	dir string
+}
+
+func (c diskCache) open(key string) (io.ReadSeekCloser, int64, bool) {
+	f, err := os.Open(filepath.Join(c.dir, key))
+	if err != nil {
+		return nil, 0, false
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, false
+	}
+	return f, fi.Size(), true
+}
+
+func (c diskCache) put(key string, b []byte) error {
+	return writeCacheFile(filepath.Join(c.dir, key), b)
+}
+
+// memoryCache keeps variants in RAM, for frames with a read-only disk. The
+// least recently used are evicted once they add up to more than maxBytes of
+// encoded image; a variant bigger than that on its own isn't kept.
+type memoryCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	used     int64
+	lru      *list.List // of *memoryEntry, most recently used first
+	entries  map[string]*list.Element
+}
+
+type memoryEntry struct {
+	key string
+	b   []byte
+}
+
+func newMemoryCache(maxBytes int64) *memoryCache {
+	return &memoryCache{maxBytes: maxBytes, lru: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func (c *memoryCache) open(key string) (io.ReadSeekCloser, int64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, 0, false
+	}
+	c.lru.MoveToFront(el)
+	b := el.Value.(*memoryEntry).b
+	return nopSeekCloser{bytes.NewReader(b)}, int64(len(b)), true
+}
+
+func (c *memoryCache) put(key string, b []byte) error {
+	size := int64(len(b))
+	if size > c.maxBytes {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.used -= int64(len(el.Value.(*memoryEntry).b))
+		c.lru.Remove(el)
+		delete(c.entries, key)
+	}
+	for c.used+size > c.maxBytes {
+		el := c.lru.Back()
+		e := el.Value.(*memoryEntry)
+		c.used -= int64(len(e.b))
+		c.lru.Remove(el)
+		delete(c.entries, e.key)
+	}
+	c.entries[key] = c.lru.PushFront(&memoryEntry{key: key, b: b})
+	c.used += size
+	return nil
+}
+
+// noCache regenerates every variant on every request.
+type noCache struct{}
+
+func (noCache) open(string) (io.ReadSeekCloser, int64, bool) { return nil, 0, false }
+func (noCache) put(string, []byte) error                     { return nil }
+
+type nopSeekCloser struct {
+	io.ReadSeeker
+}
+
+func (nopSeekCloser) Close() error { return nil }
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"image"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ---- Transcoding (formats browsers can't display) ----
+
+// transcodedExts are allowed formats that browsers can't render natively;
+// /photos/ converts them to JPEG on the fly.
+var transcodedExts = map[string]bool{
+	".heic": true,
+	".heif": true,
+}
+
+// negotiatedExts are allowed formats that only some browsers render. They're
+// served as-is to clients whose Accept header lists the media type, and
+// converted to JPEG for everyone else (when a decoder is available).
+var negotiatedExts = map[string]string{
+	".avif": "image/avif",
+}
+
+// transcodeDecoders holds the decoders compiled into this binary, keyed by
+// extension. Optional decoders register themselves from build-tagged files
+// (see heif.go, avif.go).
+var transcodeDecoders = map[string]func(io.Reader) (image.Image, error){}
+
+func needsTranscode(name string) bool {
+	return transcodedExts[strings.ToLower(filepath.Ext(name))]
+}
+
+// acceptsMediaType reports whether an Accept (or Accept-Encoding) header
+// explicitly lists mediaType. Wildcards don't count: old browsers send */*
+// without being able to decode newer formats.
+func acceptsMediaType(accept, mediaType string) bool {
+	// An explicit q=0 means "not acceptable".
+	return acceptQuality(accept, mediaType) > 0
+}
+
+// prefersJSON reports whether an Accept header ranks application/json above
+// text/html, as API clients do and browsers don't.
+func prefersJSON(accept string) bool {
+	return acceptQuality(accept, "application/json") > max(acceptQuality(accept, "text/html"), 0)
+}
+
+// acceptQuality is the q-value an Accept header gives mediaType (listed
+// explicitly), or -1 if it isn't listed.
+func acceptQuality(accept, mediaType string) float64 {
+	for _, part := range strings.Split(accept, ",") {
+		typ, params, _ := strings.Cut(part, ";")
+		if !strings.EqualFold(strings.TrimSpace(typ), mediaType) {
+			continue
+		}
+		q := 1.0
+		for _, p := range strings.Split(params, ";") {
+			if k, v, ok := strings.Cut(strings.TrimSpace(p), "="); ok && k == "q" {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					q = f
+				}
+			}
+		}
+		return q
+	}
+	return -1
+}
+
+// canDisplay reports whether a browser will be able to show the photo, either
+// directly or after transcoding.
+func canDisplay(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	if !transcodedExts[ext] {
+		return true
+	}
+	_, ok := transcodeDecoders[ext]
+	return ok
+}
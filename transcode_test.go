@@ -0,0 +1,133 @@
+package main
+
+import (
+	"image"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeHEICDecoder stands in for the build-tagged HEIC decoder with the
+// JPEG one, so the transcode path runs in a default build: a JPEG named
+// .heic gets transcoded.
+func fakeHEICDecoder(t *testing.T) {
+	t.Helper()
+	if _, ok := transcodeDecoders[".heic"]; ok {
+		return
+	}
+	transcodeDecoders[".heic"] = func(r io.Reader) (image.Image, error) {
+		img, _, err := image.Decode(r)
+		return img, err
+	}
+	t.Cleanup(func() { delete(transcodeDecoders, ".heic") })
+}
+
+func TestTranscodedConditionalGet(t *testing.T) {
+	fakeHEICDecoder(t)
+
+	caches := []struct {
+		name  string
+		cache derivedCache
+	}{
+		{"none", noCache{}},
+		{"memory", newMemoryCache(1 << 20)},
+		{"disk", diskCache{dir: t.TempDir()}},
+	}
+	for _, tc := range caches {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTestJPEG(t, dir, "x.heic", 32, 32)
+			mtime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+			if err := os.Chtimes(filepath.Join(dir, "x.heic"), mtime, mtime); err != nil {
+				t.Fatal(err)
+			}
+			srv := newPhotoServer(t, dir, &imageCache{cache: tc.cache})
+			url := srv.URL + "/photos/x.heic"
+
+			res, body := get(t, url, nil)
+			if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "image/jpeg" || len(body) == 0 {
+				t.Fatalf("first GET: %d %s, %d bytes", res.StatusCode, res.Header.Get("Content-Type"), len(body))
+			}
+			etag := res.Header.Get("ETag")
+			lastModified := res.Header.Get("Last-Modified")
+			if etag == "" {
+				t.Fatal("no ETag on the transcoded image")
+			}
+			if want := mtime.Format(http.TimeFormat); lastModified != want {
+				t.Fatalf("Last-Modified = %q, want the photo's mtime %q", lastModified, want)
+			}
+
+			testConditionalGet(t, url, etag, mtime)
+		})
+	}
+}
+
+// TestOrientedFallbackConditionalGet covers serveOriented's fallback: a
+// JPEG whose EXIF says to rotate it but whose pixels won't decode is served
+// as is, and must still answer conditional requests.
+func TestOrientedFallbackConditionalGet(t *testing.T) {
+	dir := t.TempDir()
+	// SOI, then an APP1 Exif block with Orientation 6, then no image.
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08" +
+		"\x00\x01" + // one IFD0 entry:
+		"\x01\x12\x00\x03\x00\x00\x00\x01\x00\x06\x00\x00" + // Orientation, SHORT, 1, 6
+		"\x00\x00\x00\x00")
+	app1 := append([]byte("Exif\x00\x00"), tiff...)
+	b := append([]byte{0xff, 0xd8, 0xff, 0xe1, byte((len(app1) + 2) >> 8), byte(len(app1) + 2)}, app1...)
+	b = append(b, "not an image"...)
+	p := filepath.Join(dir, "broken.jpg")
+	if err := os.WriteFile(p, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(p, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if o := photoMetas.get(p, mtime.Unix()).Orientation; o != 6 {
+		t.Fatalf("test file has Orientation %d, want 6", o)
+	}
+
+	srv := newPhotoServer(t, dir, &imageCache{cache: newMemoryCache(1 << 20), autoOrient: true})
+	url := srv.URL + "/photos/broken.jpg"
+	res, body := get(t, url, nil)
+	if res.StatusCode != http.StatusOK || string(body) != string(b) {
+		t.Fatalf("first GET: %d, %d bytes; want the original %d", res.StatusCode, len(body), len(b))
+	}
+	etag := res.Header.Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag on the fallback")
+	}
+	testConditionalGet(t, url, etag, mtime)
+}
+
+// testConditionalGet checks that url, last served with etag and the
+// Last-Modified of mtime, answers conditional GETs with 304 or 200.
+func testConditionalGet(t *testing.T, url, etag string, mtime time.Time) {
+	t.Helper()
+	lastModified := mtime.Format(http.TimeFormat)
+	tests := []struct {
+		name       string
+		header     map[string]string
+		wantStatus int
+	}{
+		{"If-None-Match", map[string]string{"If-None-Match": etag}, http.StatusNotModified},
+		{"weak If-None-Match", map[string]string{"If-None-Match": "W/" + etag}, http.StatusNotModified},
+		{"stale If-None-Match", map[string]string{"If-None-Match": `"stale"`}, http.StatusOK},
+		{"If-Modified-Since", map[string]string{"If-Modified-Since": lastModified}, http.StatusNotModified},
+		{"If-Modified-Since before", map[string]string{"If-Modified-Since": mtime.Add(-time.Hour).Format(http.TimeFormat)}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, body := get(t, url, tt.header)
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", res.StatusCode, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusNotModified && len(body) != 0 {
+				t.Errorf("304 with a %d-byte body", len(body))
+			}
+		})
+	}
+}
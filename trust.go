@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ---- Client addresses and trusted networks (TRUST_PROXY, TRUSTED_CIDRS) ----
+
+// trustProxy (TRUST_PROXY) says requests arrive through a reverse proxy, so
+// the client is taken from X-Forwarded-For / X-Real-IP. trustedProxies
+// (TRUSTED_PROXIES) are further proxies in front of that one whose hops are
+// skipped too. Both are set once at startup.
+var (
+	trustProxy     bool
+	trustedProxies []*net.IPNet
+)
+
+// parseCIDRList parses a comma-separated list of CIDRs such as
+// "192.168.1.0/24, fd00::/8". A bare IP stands for just that address.
+func parseCIDRList(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, c := range strings.Split(s, ",") {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		if !strings.Contains(c, "/") {
+			ip := net.ParseIP(c)
+			if ip == nil {
+				return nil, fmt.Errorf("%q is not an IP or CIDR", c)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an IP or CIDR", c)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// clientIP is the address of the client behind r, for everything that
+// cares (trusted networks, rate limiting, logs). By default it is the
+// connection's peer: forwarding headers are trivially made up, so they are
+// only read with TRUST_PROXY=true. Then X-Forwarded-For is walked from the
+// right, since each proxy appends the address it saw, and the first hop
+// that isn't one of trustedProxies is the client; anything left of it came
+// from the client and proves nothing. Without X-Forwarded-For, X-Real-IP is
+// used. It returns nil if the address can't be parsed.
+func clientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer := net.ParseIP(host)
+	if !trustProxy {
+		return peer
+	}
+
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	if len(hops) == 0 {
+		if real := strings.TrimSpace(r.Header.Get("X-Real-IP")); real != "" {
+			if ip := net.ParseIP(real); ip != nil {
+				return ip
+			}
+		}
+		return peer
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			// Not an address: a proxy we don't know about mangled it, or the
+			// client made it up.
+			return nil
+		}
+		if i == 0 || !inNetworks(ip, trustedProxies) {
+			return ip
+		}
+	}
+	return nil
+}
+
+// clientAddr is clientIP as a string, falling back to the connection's
+// host when there is no usable address.
+func clientAddr(r *http.Request) string {
+	if ip := clientIP(r); ip != nil {
+		return ip.String()
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// inNetworks reports whether ip is inside any of nets.
+func inNetworks(ip net.IP, nets []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
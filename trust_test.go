@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	cdn, err := parseCIDRList("203.0.113.0/24, 2001:db8::/32")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		trustProxy bool
+		proxies    []*net.IPNet
+		remote     string
+		xff        []string
+		realIP     string
+		want       string
+	}{
+		// Not behind a proxy: whatever the client claims is ignored.
+		{name: "no proxy, plain", remote: "198.51.100.7:5000", want: "198.51.100.7"},
+		{name: "no proxy, spoofed XFF", remote: "198.51.100.7:5000", xff: []string{"192.168.1.10"}, want: "198.51.100.7"},
+		{name: "no proxy, spoofed X-Real-IP", remote: "198.51.100.7:5000", realIP: "192.168.1.10", want: "198.51.100.7"},
+
+		// Behind one proxy: it appends the address it saw.
+		{name: "proxy, one hop", trustProxy: true, remote: "10.0.0.2:80", xff: []string{"198.51.100.7"}, want: "198.51.100.7"},
+		{name: "proxy, client prepends a fake hop", trustProxy: true, remote: "10.0.0.2:80",
+			xff: []string{"192.168.1.10, 198.51.100.7"}, want: "198.51.100.7"},
+		{name: "proxy, fake hop in its own header", trustProxy: true, remote: "10.0.0.2:80",
+			xff: []string{"192.168.1.10", "198.51.100.7"}, want: "198.51.100.7"},
+		{name: "proxy, X-Real-IP without XFF", trustProxy: true, remote: "10.0.0.2:80", realIP: "198.51.100.7", want: "198.51.100.7"},
+		{name: "proxy, no headers", trustProxy: true, remote: "10.0.0.2:80", want: "10.0.0.2"},
+		{name: "proxy, garbage hop", trustProxy: true, remote: "10.0.0.2:80", xff: []string{"not-an-ip"}, want: ""},
+
+		// A CDN in front of the proxy: its hops are skipped too, but nothing
+		// left of the first untrusted one counts.
+		{name: "chain through TRUSTED_PROXIES", trustProxy: true, proxies: cdn, remote: "10.0.0.2:80",
+			xff: []string{"198.51.100.7, 203.0.113.5"}, want: "198.51.100.7"},
+		{name: "chain, IPv6 CDN hop", trustProxy: true, proxies: cdn, remote: "10.0.0.2:80",
+			xff: []string{"198.51.100.7, 2001:db8::1"}, want: "198.51.100.7"},
+		{name: "chain, client spoofs beyond the CDN", trustProxy: true, proxies: cdn, remote: "10.0.0.2:80",
+			xff: []string{"192.168.1.10, 198.51.100.7, 203.0.113.5"}, want: "198.51.100.7"},
+		{name: "chain, client spoofs a CDN address", trustProxy: true, proxies: cdn, remote: "10.0.0.2:80",
+			xff: []string{"203.0.113.9, 198.51.100.7, 203.0.113.5"}, want: "198.51.100.7"},
+		{name: "chain, every hop trusted", trustProxy: true, proxies: cdn, remote: "10.0.0.2:80",
+			xff: []string{"203.0.113.9, 203.0.113.5"}, want: "203.0.113.9"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(tp bool, ps []*net.IPNet) { trustProxy, trustedProxies = tp, ps }(trustProxy, trustedProxies)
+			trustProxy, trustedProxies = tt.trustProxy, tt.proxies
+
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remote
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+
+			got := ""
+			if ip := clientIP(r); ip != nil {
+				got = ip.String()
+			}
+			if got != tt.want {
+				t.Errorf("clientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// ---- Upload (POST /api/upload) ----
+
+type UploadResponse struct {
+	// Stored lists the names the files were saved under, which can differ
+	// from the uploaded names (sanitized, or suffixed to avoid overwriting).
+	Stored []string `json:"stored"`
+}
+
+// uploadHandler accepts multipart/form-data uploads and stores every file
+// part in dir. Files are staged first and only moved into place once the
+// whole request has been read and checked, so a bad part stores nothing.
+func uploadHandler(dir string, maxBytes int64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+		mr, err := r.MultipartReader()
+		if err != nil {
+			http.Error(w, "expected a multipart/form-data body", http.StatusBadRequest)
+			return
+		}
+
+		type staged struct{ tmp, name string }
+		var files []staged
+		defer func() {
+			for _, f := range files {
+				os.Remove(f.tmp)
+			}
+		}()
+
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				uploadError(w, r, err)
+				return
+			}
+			if part.FileName() == "" {
+				// Ordinary form field; nothing to store.
+				part.Close()
+				continue
+			}
+
+			name := sanitizeFilename(part.FileName())
+			if name == "" || !isAllowedExt(name) {
+				http.Error(w, fmt.Sprintf("%q: file type not allowed", part.FileName()), http.StatusUnsupportedMediaType)
+				return
+			}
+
+			tmp, err := stageUpload(dir, name, part)
+			part.Close()
+			if tmp != "" {
+				files = append(files, staged{tmp: tmp, name: name})
+			}
+			if err != nil {
+				uploadError(w, r, err)
+				return
+			}
+		}
+		if len(files) == 0 {
+			http.Error(w, "no files in upload", http.StatusBadRequest)
+			return
+		}
+
+		resp := UploadResponse{Stored: []string{}}
+		for _, f := range files {
+			stored, err := placeUpload(dir, f.tmp, f.name)
+			if err != nil {
+				logRequestf(r, "upload error: %s: %v", f.name, err)
+				http.Error(w, "failed to store upload", http.StatusInternalServerError)
+				return
+			}
+			logRequestf(r, "Uploaded %s", stored)
+			resp.Stored = append(resp.Stored, stored)
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		writeJSON(w, resp)
+	}
+}
+
+var errContentMismatch = errors.New("file contents don't match its extension")
+
+// stageUpload copies one part into a hidden temp file in dir, checking the
+// sniffed content type against the extension on the way. It returns the
+// temp file's path even on error so the caller can clean it up.
+func stageUpload(dir, name string, part io.Reader) (string, error) {
+	br := bufio.NewReaderSize(part, 512)
+	head, err := br.Peek(512)
+	if err != nil && err != io.EOF && !errors.Is(err, bufio.ErrBufferFull) {
+		return "", err
+	}
+	if !sniffMatchesExt(strings.ToLower(filepath.Ext(name)), head) {
+		return "", fmt.Errorf("%s: %w", name, errContentMismatch)
+	}
+
+	// The dot prefix and missing extension keep scans from picking it up.
+	tmp, err := os.CreateTemp(dir, ".upload-*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(tmp, br); err != nil {
+		tmp.Close()
+		return tmp.Name(), err
+	}
+	return tmp.Name(), tmp.Close()
+}
+
+// placeUpload moves a staged upload to name in dir, adding "-1", "-2", …
+// before the extension rather than overwriting an existing photo. Names
+// are claimed with a hard link, which fails if the name exists, so two
+// uploads of the same name at once can't both take it.
+func placeUpload(dir, tmp, name string) (string, error) {
+	if err := os.Chmod(tmp, 0o644); err != nil {
+		return "", err
+	}
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	for i := 0; i < 1000; i++ {
+		candidate := name
+		if i > 0 {
+			candidate = fmt.Sprintf("%s-%d%s", stem, i, ext)
+		}
+		dst, err := safeJoin(dir, candidate)
+		if err != nil {
+			return "", err
+		}
+		if err := os.Link(tmp, dst); err != nil {
+			if errors.Is(err, fs.ErrExist) {
+				continue
+			}
+			return "", err
+		}
+		os.Remove(tmp)
+		return candidate, nil
+	}
+	return "", errors.New("too many files with that name")
+}
+
+func uploadError(w http.ResponseWriter, r *http.Request, err error) {
+	var tooBig *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooBig):
+		http.Error(w, fmt.Sprintf("upload larger than %d bytes", tooBig.Limit), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, errContentMismatch):
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+	default:
+		logRequestf(r, "upload error: %v", err)
+		http.Error(w, "failed to read upload", http.StatusBadRequest)
+	}
+}
+
+// sanitizeFilename reduces an uploaded file name to a plain base name made
+// of letters, digits and a few punctuation characters. It returns "" if
+// nothing usable is left.
+func sanitizeFilename(name string) string {
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsLetter(r), unicode.IsDigit(r):
+			return r
+		case strings.ContainsRune(" ._-()#", r):
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+	// No hidden files, and nothing that is only an extension.
+	name = strings.TrimLeft(strings.TrimSpace(name), ".")
+	if name == "" || strings.TrimSuffix(name, filepath.Ext(name)) == "" {
+		return ""
+	}
+	return name
+}
+
+// sniffMatchesExt reports whether the first bytes of a file look like the
+// format its extension claims.
+func sniffMatchesExt(ext string, head []byte) bool {
+	switch ext {
+	case ".heic", ".heif", ".avif", ".mp4":
+		// ISO BMFF containers, which http.DetectContentType doesn't name
+		// (or, for MP4, only with some brands).
+		return len(head) >= 12 && string(head[4:8]) == "ftyp"
+	}
+	want, _, _ := mime.ParseMediaType(mime.TypeByExtension(ext))
+	if want == "" {
+		return false
+	}
+	got, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	return got == want
+}
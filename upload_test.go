@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/jpeg"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentUploadsKeepEveryFile(t *testing.T) {
+	dir := t.TempDir()
+	existing := writeTestJPEG(t, dir, "a.jpg", 8, 8)
+	srv := httptest.NewServer(uploadHandler(dir, 1<<20))
+	t.Cleanup(srv.Close)
+
+	const n = 20
+	bodies := make([][]byte, n)
+	stored := make([]string, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		// A different size each, so every upload's bytes are distinct.
+		var img bytes.Buffer
+		if err := jpeg.Encode(&img, image.NewGray(image.Rect(0, 0, i+1, 1)), nil); err != nil {
+			t.Fatal(err)
+		}
+		bodies[i] = img.Bytes()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var form bytes.Buffer
+			mw := multipart.NewWriter(&form)
+			part, err := mw.CreateFormFile("file", "a.jpg")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			part.Write(bodies[i])
+			mw.Close()
+
+			res, err := http.Post(srv.URL, mw.FormDataContentType(), &form)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer res.Body.Close()
+			if res.StatusCode != http.StatusCreated {
+				t.Errorf("upload %d: status %d", i, res.StatusCode)
+				return
+			}
+			var resp UploadResponse
+			if err := json.NewDecoder(res.Body).Decode(&resp); err != nil || len(resp.Stored) != 1 {
+				t.Errorf("upload %d: %+v, %v", i, resp, err)
+				return
+			}
+			stored[i] = resp.Stored[0]
+		}()
+	}
+	wg.Wait()
+	if t.Failed() {
+		return
+	}
+
+	seen := map[string]bool{"a.jpg": true}
+	for i, name := range stored {
+		if seen[name] {
+			t.Errorf("upload %d stored as %s, which was already taken", i, name)
+		}
+		seen[name] = true
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil || !bytes.Equal(got, bodies[i]) {
+			t.Errorf("%s doesn't hold upload %d: %v", name, i, err)
+		}
+	}
+	if fi, err := os.Stat(filepath.Join(dir, "a.jpg")); err != nil || fi.Size() != existing {
+		t.Errorf("existing a.jpg was replaced: %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".upload-") {
+			t.Errorf("staged file %s left behind", e.Name())
+		}
+	}
+}
+
+func TestPlaceUploadClaimsNamesOnce(t *testing.T) {
+	dir := t.TempDir()
+	const n = 50
+	tmps := make([]string, n)
+	for i := range tmps {
+		f, err := os.CreateTemp(dir, ".upload-*")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString(strings.Repeat("x", i))
+		f.Close()
+		tmps[i] = f.Name()
+	}
+
+	// Release every placement at once so they race for the same names.
+	start := make(chan struct{})
+	stored := make([]string, n)
+	var wg sync.WaitGroup
+	for i, tmp := range tmps {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			name, err := placeUpload(dir, tmp, "a.jpg")
+			if err != nil {
+				t.Error(err)
+			}
+			stored[i] = name
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	seen := make(map[string]bool)
+	for i, name := range stored {
+		if seen[name] {
+			t.Errorf("%s claimed twice", name)
+		}
+		seen[name] = true
+		if fi, err := os.Stat(filepath.Join(dir, name)); err != nil || fi.Size() != int64(i) {
+			t.Errorf("%s doesn't hold upload %d: %v", name, i, err)
+		}
+		if _, err := os.Stat(tmps[i]); err == nil {
+			t.Errorf("staged file for %s left behind", name)
+		}
+	}
+}
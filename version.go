@@ -0,0 +1,43 @@
+package main
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// ---- Build info (/version) ----
+
+// Set at build time with -ldflags, e.g.
+//
+//	go build -ldflags "-X main.version=1.4.0 -X main.commit=$(git rev-parse --short HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+type VersionResponse struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+}
+
+// buildInfo describes this binary. Without ldflags, a plain go build from a
+// git checkout still records the commit, so that is used when available.
+func buildInfo() VersionResponse {
+	info := VersionResponse{
+		Version:   version,
+		Commit:    commit,
+		BuildDate: buildDate,
+		GoVersion: runtime.Version(),
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			if s.Key == "vcs.revision" && info.Commit == "unknown" {
+				info.Commit = s.Value
+			}
+		}
+	}
+	return info
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"mime"
+	"path/filepath"
+	"strings"
+)
+
+// ---- Video clips (ALLOW_VIDEO) ----
+
+// videoExts are the clip formats browsers play natively, with their media
+// types. They're only served with ALLOW_VIDEO=true.
+var videoExts = map[string]string{
+	".mp4":  "video/mp4",
+	".webm": "video/webm",
+}
+
+// allowVideo (ALLOW_VIDEO) adds videoExts to the photo allowlist.
+var allowVideo bool
+
+// enableVideo turns on video clips. The media types are registered with
+// the mime package too, so every handler that goes by extension (and the
+// upload sniffing) gets them right on systems without /etc/mime.types.
+func enableVideo() {
+	allowVideo = true
+	for ext, ct := range videoExts {
+		mime.AddExtensionType(ext, ct)
+	}
+}
+
+// isVideo reports whether name is a video clip that may be served.
+func isVideo(name string) bool {
+	_, ok := videoExts[strings.ToLower(filepath.Ext(name))]
+	return ok && allowVideo
+}
+
+// photoKind is the Photo.Kind of name: "video" for clips, else "image".
+func photoKind(name string) string {
+	if isVideo(name) {
+		return "video"
+	}
+	return "image"
+}
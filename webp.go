@@ -0,0 +1,32 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"mime"
+)
+
+// ---- Animated WebP ----
+
+// The WebP decoder only handles still images, so an animated WebP must
+// never be scaled or converted: it would lose all but one frame, if it
+// decoded at all. makeThumb passes such files through untouched instead.
+
+func init() {
+	// Registered so /etc/mime.types can't send WebP as anything else.
+	mime.AddExtensionType(".webp", "image/webp")
+}
+
+// webpAnimated reports whether r starts with the header of an animated
+// WebP: "RIFF" size "WEBP", then the extended-format "VP8X" chunk (which
+// must come first) with the animation bit (0x02) in its flags byte.
+func webpAnimated(r io.Reader) bool {
+	var hdr [21]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return false
+	}
+	return bytes.Equal(hdr[0:4], []byte("RIFF")) &&
+		bytes.Equal(hdr[8:12], []byte("WEBP")) &&
+		bytes.Equal(hdr[12:16], []byte("VP8X")) &&
+		hdr[20]&0x02 != 0
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testdata/animated.webp is a 16x16 two-frame animation (red, then blue),
+// testdata/still.webp a single 16x16 frame.
+
+func TestWebpAnimated(t *testing.T) {
+	tests := []struct {
+		file string
+		want bool
+	}{
+		{"animated.webp", true},
+		{"still.webp", false},
+	}
+	for _, tt := range tests {
+		f, err := os.Open(filepath.Join("testdata", tt.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := webpAnimated(f)
+		f.Close()
+		if got != tt.want {
+			t.Errorf("webpAnimated(%s) = %v, want %v", tt.file, got, tt.want)
+		}
+	}
+	if webpAnimated(strings.NewReader("RIFF")) {
+		t.Error("a truncated header counts as animated")
+	}
+}
+
+func TestAnimatedWebPPassesThrough(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"animated.webp", "still.webp"} {
+		b, err := os.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	images := &imageCache{cache: newMemoryCache(1 << 20)}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/thumb/")
+		fullPath, fi, ok := lookupPhoto(dir, false, name)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		images.serveThumb(w, r, name, fullPath, fi, 4)
+	}))
+	defer srv.Close()
+
+	original, err := os.ReadFile(filepath.Join(dir, "animated.webp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, body := get(t, srv.URL+"/thumb/animated.webp", nil)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("animated: status = %d", res.StatusCode)
+	}
+	if ct := res.Header.Get("Content-Type"); ct != "image/webp" {
+		t.Errorf("animated: Content-Type = %q, want image/webp", ct)
+	}
+	if !bytes.Equal(body, original) {
+		t.Errorf("animated: got %d bytes that aren't the original's %d", len(body), len(original))
+	}
+
+	// A still WebP is scaled down as usual.
+	res, body = get(t, srv.URL+"/thumb/still.webp", nil)
+	if ct := res.Header.Get("Content-Type"); ct != "image/jpeg" {
+		t.Fatalf("still: Content-Type = %q, want image/jpeg", ct)
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 4 {
+		t.Errorf("still: thumbnail is %d wide, want 4", cfg.Width)
+	}
+}
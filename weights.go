@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ---- Weights (order=weighted) ----
+
+const (
+	// weightsFile, in PHOTOS_DIR, assigns weights by photo name:
+	//   {"weights": {"wedding.jpg": 5, "vacation/beach.jpg": 2}}
+	weightsFile = "frameserve.json"
+	// Photos with favMarker in their file name ("dog #fav.jpg") get favWeight
+	// unless weightsFile says otherwise.
+	favMarker = "#fav"
+	favWeight = 3
+	// maxWeight keeps a typo from flooding the playlist with one photo.
+	maxWeight = 100
+)
+
+type weightsConfig struct {
+	Weights map[string]int `json:"weights"`
+}
+
+// loadWeights reads weightsFile from dir. A missing file means no weights;
+// a broken one is logged and ignored.
+func loadWeights(dir string) map[string]int {
+	b, err := os.ReadFile(filepath.Join(dir, weightsFile))
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("weights: %v", err)
+		}
+		return nil
+	}
+	var cfg weightsConfig
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		log.Printf("weights: %s: %v", weightsFile, err)
+		return nil
+	}
+	return cfg.Weights
+}
+
+// applyWeights sets Weight on every photo: weights (from loadWeights)
+// first, then the favMarker convention, otherwise 1.
+func applyWeights(weights map[string]int, photos []Photo) {
+	for i := range photos {
+		w, ok := weights[photos[i].Name]
+		if !ok && strings.Contains(strings.ToLower(path.Base(photos[i].Name)), favMarker) {
+			w = favWeight
+		}
+		photos[i].Weight = min(max(w, 1), maxWeight)
+	}
+}
+
+// weightedPlaylist repeats each photo Weight times, spreading the copies of
+// a photo evenly across the list so favorites come back regularly rather
+// than in clumps. The result only depends on the names and weights, so it is
+// stable between polls. Without any weights the input is returned as is.
+func weightedPlaylist(photos []Photo) []Photo {
+	type slot struct {
+		pos   float64
+		photo Photo
+	}
+
+	weighted := false
+	total := 0
+	for _, p := range photos {
+		total += p.Weight
+		if p.Weight > 1 {
+			weighted = true
+		}
+	}
+	if !weighted {
+		return photos
+	}
+
+	slots := make([]slot, 0, total)
+	for _, p := range photos {
+		// A per-photo offset in [0, 1) so photos don't all line up at
+		// the same positions.
+		h := fnv.New64a()
+		h.Write([]byte(p.Name))
+		offset := float64(h.Sum64()>>11) / (1 << 53)
+
+		for k := 0; k < p.Weight; k++ {
+			slots = append(slots, slot{pos: (float64(k) + offset) / float64(p.Weight), photo: p})
+		}
+	}
+	sort.SliceStable(slots, func(i, j int) bool { return slots[i].pos < slots[j].pos })
+
+	playlist := make([]Photo, len(slots))
+	for i, s := range slots {
+		playlist[i] = s.photo
+	}
+	return playlist
+}